@@ -0,0 +1,560 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// adminUsage lists the admin subcommands.
+const adminUsage = `usage: toilet-redux admin <command> [arguments]
+
+  list [-hidden] [-json]   list the messages, or only the hidden ones
+  show [-json] <id>        show a message, its votes and its replies
+  delete <id>              delete a message and its replies
+  hide <id>                hide a message from the wall
+  unhide <id>              put a hidden message back on the wall
+  export [file]            write every message and vote as JSON
+  import <file>            add the messages and votes from an export
+  stats [-json]            count messages, votes and authors
+  compact                  give back space taken by changed and deleted messages
+  pin <id>                 keep a message from ever expiring
+  unpin <id>               let a pinned message expire again
+  expire <id> <when>       expire a message in n days, on a date, or by the
+                           wall's rule again with "default"
+  cleanup                  hide expired messages and archive old ones now
+
+The store is the one named in the config file.`
+
+// wallExport is what admin export writes and admin import reads.
+type wallExport struct {
+	Messages []Message `json:"messages"`
+	Votes    []Vote    `json:"votes"`
+}
+
+// wallStats is what admin stats reports.
+type wallStats struct {
+	Messages    int       `json:"messages"`
+	Posts       int       `json:"posts"`
+	Replies     int       `json:"replies"`
+	Hidden      int       `json:"hidden"`
+	Anonymous   int       `json:"anonymous"`
+	FlushVotes  int       `json:"flush_votes"`
+	KeepVotes   int       `json:"keep_votes"`
+	Authors     int       `json:"authors"`
+	TopAuthors  []count   `json:"top_authors"`
+	FirstPosted time.Time `json:"first_posted"`
+	LastPosted  time.Time `json:"last_posted"`
+}
+
+type count struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+// adminCommand handles "toilet-redux admin ...", which lets the sysop
+// moderate the wall from a shell or cron. It works on the same store as the
+// door, through the same locking, so it is safe to run while callers are on.
+func adminCommand(args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, adminUsage)
+		return 2
+	}
+	s, err := openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	var run func(Store, []string) error
+	switch args[0] {
+	case "list":
+		run = adminList
+	case "show":
+		run = adminShow
+	case "delete":
+		run = adminDelete
+	case "hide":
+		run = func(s Store, args []string) error { return adminHide(s, args, true) }
+	case "unhide":
+		run = func(s Store, args []string) error { return adminHide(s, args, false) }
+	case "export":
+		run = adminExport
+	case "import":
+		run = adminImport
+	case "stats":
+		run = adminStats
+	case "compact":
+		run = adminCompact
+	case "pin":
+		run = func(s Store, args []string) error { return adminPin(s, args, true) }
+	case "unpin":
+		run = func(s Store, args []string) error { return adminPin(s, args, false) }
+	case "expire":
+		run = adminExpire
+	case "cleanup":
+		run = adminCleanup
+	default:
+		fmt.Fprintf(os.Stderr, "unknown admin command %q\n\n%s\n", args[0], adminUsage)
+		return 2
+	}
+
+	if err := run(s, args[1:]); err == flag.ErrHelp {
+		return 2
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "admin %s: %v\n", args[0], err)
+		return 1
+	}
+	switch args[0] {
+	case "delete", "hide", "unhide", "import", "pin", "unpin", "expire", "cleanup":
+		if err := refreshBulletin(s); err != nil {
+			fmt.Fprintf(os.Stderr, "bulletin: %v\n", err)
+			return 1
+		}
+	}
+	return 0
+}
+
+func adminList(s Store, args []string) error {
+	fs := flag.NewFlagSet("admin list", flag.ContinueOnError)
+	hiddenOnly := fs.Bool("hidden", false, "only list hidden messages")
+	asJSON := fs.Bool("json", false, "write JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	msgs, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	var list []Message
+	for _, m := range msgs {
+		if !*hiddenOnly || m.Hidden {
+			list = append(list, m)
+		}
+	}
+	if *asJSON {
+		return writeJSON(os.Stdout, list)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tREPLY TO\tPOSTED\tAUTHOR\tKEEP\tFLUSH\tFLAGS\tMESSAGE")
+	for _, m := range list {
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%d\t%d\t%s\t%s\n", m.ID, parentColumn(m), showTime(m.Posted, time.Now()),
+			authorColumn(m), m.Keep, m.Flush, flagsColumn(m), abbreviate(stripMarkup(m.Body), 40))
+	}
+	return w.Flush()
+}
+
+func adminShow(s Store, args []string) error {
+	fs := flag.NewFlagSet("admin show", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of text")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	id, err := idArg(fs.Args())
+	if err != nil {
+		return err
+	}
+
+	msgs, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	votes, err := s.Votes()
+	if err != nil {
+		return err
+	}
+	var m *Message
+	var replies []Message
+	for i := range msgs {
+		if msgs[i].ID == id {
+			m = &msgs[i]
+		} else if msgs[i].Parent == id {
+			replies = append(replies, msgs[i])
+		}
+	}
+	if m == nil {
+		return fmt.Errorf("no message %d", id)
+	}
+	var cast []Vote
+	for _, v := range votes {
+		if v.ID == id {
+			cast = append(cast, v)
+		}
+	}
+
+	if *asJSON {
+		return writeJSON(os.Stdout, struct {
+			Message
+			Votes   []Vote    `json:"votes"`
+			Replies []Message `json:"replies"`
+		}{*m, cast, replies})
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "ID\t%d\n", m.ID)
+	if m.Parent != 0 {
+		fmt.Fprintf(w, "Reply to\t%d\n", m.Parent)
+	}
+	fmt.Fprintf(w, "Posted\t%s\n", showTime(m.Posted, time.Now()))
+	fmt.Fprintf(w, "Author\t%s\n", authorColumn(*m))
+	fmt.Fprintf(w, "Votes\t%d keep, %d flush\n", m.Keep, m.Flush)
+	if flags := flagsColumn(*m); flags != "" {
+		fmt.Fprintf(w, "Flags\t%s\n", flags)
+	}
+	if !m.Expires.IsZero() {
+		fmt.Fprintf(w, "Expires\t%s\n", showTime(m.Expires, time.Now()))
+	}
+	fmt.Fprintf(w, "Message\t%s\n", m.Body)
+	for _, v := range cast {
+		vote := "keep"
+		if v.Flush {
+			vote = "flush"
+		}
+		fmt.Fprintf(w, "Vote\t%s by %s\n", vote, v.Alias)
+	}
+	for _, r := range replies {
+		fmt.Fprintf(w, "Reply\t%d by %s: %s\n", r.ID, authorColumn(r), abbreviate(stripMarkup(r.Body), 50))
+	}
+	return w.Flush()
+}
+
+func adminDelete(s Store, args []string) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	n, err := s.Delete(id)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("deleted message %d", id)
+	if n > 1 {
+		fmt.Printf(" and %d replies", n-1)
+	}
+	fmt.Println()
+	return nil
+}
+
+func adminHide(s Store, args []string, hidden bool) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Hide(id, hidden); err != nil {
+		return err
+	}
+	if hidden {
+		fmt.Printf("hid message %d\n", id)
+	} else {
+		fmt.Printf("message %d is back on the wall\n", id)
+	}
+	return nil
+}
+
+func adminExport(s Store, args []string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("export takes at most one file")
+	}
+	msgs, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	votes, err := s.Votes()
+	if err != nil {
+		return err
+	}
+	export := wallExport{Messages: msgs, Votes: votes}
+	if len(args) == 0 {
+		return writeJSON(os.Stdout, export)
+	}
+
+	file, err := os.Create(args[0])
+	if err != nil {
+		return err
+	}
+	if err := writeJSON(file, export); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// adminImport adds the messages in an export to the store. Messages whose
+// ids are already taken are skipped, along with their votes, so importing
+// the same file twice does nothing the second time. Messages are cleaned
+// like posts typed in the door, and ones that show nothing after it are
+// refused.
+func adminImport(s Store, args []string) error {
+	if len(args) != 1 {
+		return fmt.Errorf("import takes one file")
+	}
+	data, err := os.ReadFile(args[0])
+	if err != nil {
+		return err
+	}
+	var in wallExport
+	if err := json.Unmarshal(data, &in); err != nil {
+		return fmt.Errorf("%s: %v", args[0], err)
+	}
+
+	existing, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	taken := map[int]bool{}
+	for _, m := range existing {
+		taken[m.ID] = true
+	}
+	var msgs []Message
+	added := map[int]bool{}
+	refused := 0
+	for _, m := range in.Messages {
+		if m.ID < 1 || taken[m.ID] || added[m.ID] {
+			continue
+		}
+		if err := cleanMessage(&m); err != nil {
+			fmt.Fprintf(os.Stderr, "message %d refused: %v\n", m.ID, err)
+			refused++
+			continue
+		}
+		msgs = append(msgs, m)
+		added[m.ID] = true
+	}
+	var votes []Vote
+	for _, v := range in.Votes {
+		if added[v.ID] {
+			votes = append(votes, v)
+		}
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+
+	if err := s.Import(msgs, votes); err != nil {
+		return err
+	}
+	fmt.Printf("imported %d messages and %d votes", len(msgs), len(votes))
+	if skipped := len(in.Messages) - len(msgs) - refused; skipped > 0 {
+		fmt.Printf(", skipped %d already on the wall", skipped)
+	}
+	if refused > 0 {
+		fmt.Printf(", refused %d", refused)
+	}
+	fmt.Println()
+	return nil
+}
+
+func adminStats(s Store, args []string) error {
+	fs := flag.NewFlagSet("admin stats", flag.ContinueOnError)
+	asJSON := fs.Bool("json", false, "write JSON instead of a table")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	msgs, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	votes, err := s.Votes()
+	if err != nil {
+		return err
+	}
+	st := wallStatsOf(msgs, votes)
+	if *asJSON {
+		return writeJSON(os.Stdout, st)
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Messages\t%d\n", st.Messages)
+	fmt.Fprintf(w, "  posts\t%d\n", st.Posts)
+	fmt.Fprintf(w, "  replies\t%d\n", st.Replies)
+	fmt.Fprintf(w, "  hidden\t%d\n", st.Hidden)
+	fmt.Fprintf(w, "  anonymous\t%d\n", st.Anonymous)
+	fmt.Fprintf(w, "Votes\t%d keep, %d flush\n", st.KeepVotes, st.FlushVotes)
+	fmt.Fprintf(w, "Authors\t%d\n", st.Authors)
+	for _, a := range st.TopAuthors {
+		fmt.Fprintf(w, "  %s\t%d\n", a.Name, a.Count)
+	}
+	if !st.FirstPosted.IsZero() {
+		fmt.Fprintf(w, "First post\t%s\n", showTime(st.FirstPosted, time.Now()))
+		fmt.Fprintf(w, "Last post\t%s\n", showTime(st.LastPosted, time.Now()))
+	}
+	return w.Flush()
+}
+
+// wallStatsOf counts up the wall. Anonymous posts count towards the
+// message totals but not their author's.
+func wallStatsOf(msgs []Message, votes []Vote) wallStats {
+	st := wallStats{Messages: len(msgs)}
+	authors := map[string]int{}
+	for _, m := range msgs {
+		if m.Parent == 0 {
+			st.Posts++
+		} else {
+			st.Replies++
+		}
+		if m.Hidden {
+			st.Hidden++
+		}
+		if m.Anonymous {
+			st.Anonymous++
+		} else {
+			authors[m.Author]++
+		}
+		if m.Posted.IsZero() {
+			continue // a date that didn't parse
+		}
+		if st.FirstPosted.IsZero() || m.Posted.Before(st.FirstPosted) {
+			st.FirstPosted = m.Posted
+		}
+		if m.Posted.After(st.LastPosted) {
+			st.LastPosted = m.Posted
+		}
+	}
+	for _, v := range votes {
+		if v.Flush {
+			st.FlushVotes++
+		} else {
+			st.KeepVotes++
+		}
+	}
+
+	st.Authors = len(authors)
+	for name, n := range authors {
+		st.TopAuthors = append(st.TopAuthors, count{name, n})
+	}
+	sort.Slice(st.TopAuthors, func(i, j int) bool {
+		a, b := st.TopAuthors[i], st.TopAuthors[j]
+		return a.Count > b.Count || (a.Count == b.Count && a.Name < b.Name)
+	})
+	if len(st.TopAuthors) > 5 {
+		st.TopAuthors = st.TopAuthors[:5]
+	}
+	return st
+}
+
+func adminCompact(s Store, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("compact takes no arguments")
+	}
+	if err := s.Compact(); err != nil {
+		return err
+	}
+	fmt.Println("compacted")
+	return nil
+}
+
+func adminPin(s Store, args []string, pinned bool) error {
+	id, err := idArg(args)
+	if err != nil {
+		return err
+	}
+	if _, err := s.Change(id, func(m *Message) { m.Pinned = pinned }); err != nil {
+		return err
+	}
+	if pinned {
+		fmt.Printf("pinned message %d\n", id)
+	} else {
+		fmt.Printf("unpinned message %d\n", id)
+	}
+	return nil
+}
+
+func adminExpire(s Store, args []string) error {
+	if len(args) != 2 {
+		return fmt.Errorf("expire takes a message id and when: days, a date or default")
+	}
+	id, err := idArg(args[:1])
+	if err != nil {
+		return err
+	}
+	expires, err := parseExpiry(args[1], time.Now())
+	if err != nil {
+		return err
+	}
+	if _, err := s.Change(id, func(m *Message) { m.Expires = expires }); err != nil {
+		return err
+	}
+	if expires.IsZero() {
+		fmt.Printf("message %d follows the wall's expiry rule\n", id)
+	} else {
+		fmt.Printf("message %d expires %s\n", id, showTime(expires, time.Now()))
+	}
+	return nil
+}
+
+func adminCleanup(s Store, args []string) error {
+	if len(args) != 0 {
+		return fmt.Errorf("cleanup takes no arguments")
+	}
+	done, err := maintain(s, cfg, time.Now())
+	if err != nil {
+		return err
+	}
+	fmt.Printf("hid %d expired messages, archived %d\n", done.Hidden, done.Archived)
+	return nil
+}
+
+// idArg reads the single message id an admin command takes.
+func idArg(args []string) (int, error) {
+	if len(args) != 1 {
+		return 0, fmt.Errorf("give one message id")
+	}
+	id, err := strconv.Atoi(args[0])
+	if err != nil || id < 1 {
+		return 0, fmt.Errorf("bad message id %q", args[0])
+	}
+	return id, nil
+}
+
+func writeJSON(w io.Writer, v interface{}) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+func parentColumn(m Message) string {
+	if m.Parent == 0 {
+		return "-"
+	}
+	return strconv.Itoa(m.Parent)
+}
+
+// authorColumn shows who really posted a message, marking anonymous posts.
+func authorColumn(m Message) string {
+	name := m.Author
+	if m.Anonymous {
+		name += " (anon)"
+	}
+	if m.Origin != "" {
+		name += "@" + m.Origin
+	}
+	return name
+}
+
+func flagsColumn(m Message) string {
+	var flags []string
+	if m.Hidden {
+		flags = append(flags, "hidden")
+	}
+	if m.Pinned {
+		flags = append(flags, "pinned")
+	}
+	return strings.Join(flags, ",")
+}
+
+// abbreviate cuts text down to n characters, on one line.
+func abbreviate(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > n {
+		return string(r[:n-3]) + "..."
+	}
+	return text
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// The bulletin is a "latest graffiti" screen for the BBS to show at login:
+// the newest posts on the wall, each in a copy of the stall's message box
+// with its byline beside it. It is written as .ans, or as plain text when
+// the file name ends in .asc.
+
+const bulletinWidth = 80
+
+// bulletinCommand handles "toilet-redux bulletin", which writes the
+// bulletin without entering the door.
+func bulletinCommand(args []string) int {
+	fs := flag.NewFlagSet("bulletin", flag.ContinueOnError)
+	count := fs.Int("n", cfg.BulletinCount, "number of posts")
+	outPath := fs.String("o", cfg.Bulletin, "file to write, .ans or .asc (default: standard output as ANSI)")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 || *count < 1 {
+		fmt.Fprintln(os.Stderr, "usage: toilet-redux bulletin [-n posts] [-o file]")
+		return 2
+	}
+
+	s, err := openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+	theme = selectTheme(cfg, time.Now())
+
+	if *outPath == "" {
+		data, err := renderBulletin(s, *count, false)
+		if err == nil {
+			_, err = os.Stdout.Write(data)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "bulletin: %v\n", err)
+			return 1
+		}
+		return 0
+	}
+	if err := writeBulletin(s, *outPath, *count); err != nil {
+		fmt.Fprintf(os.Stderr, "bulletin: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// refreshBulletin rewrites the bulletin named in the config file, if there
+// is one, after the wall changes.
+func refreshBulletin(s Store) error {
+	if cfg.Bulletin == "" {
+		return nil
+	}
+	return writeBulletin(s, cfg.Bulletin, cfg.BulletinCount)
+}
+
+// writeBulletin renders the newest count posts and swaps the file at path
+// for them in one go, so the BBS never shows half a bulletin.
+func writeBulletin(s Store, path string, count int) error {
+	ascii := strings.EqualFold(filepath.Ext(path), ".asc")
+	data, err := renderBulletin(s, count, ascii)
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(path, data)
+}
+
+// renderBulletin draws the newest count visible posts, newest first, after
+// any pinned ones.
+func renderBulletin(s Store, count int, ascii bool) ([]byte, error) {
+	posts, err := s.Pinned()
+	if err != nil {
+		return nil, err
+	}
+	if len(posts) > count {
+		posts = posts[:count]
+	}
+	unpinned := func(m *Message) bool { return !m.Pinned }
+	from := math.MaxInt32
+	for len(posts) < count {
+		m, err := s.Seek(from, -1, unpinned)
+		if err != nil {
+			return nil, err
+		}
+		if m == nil {
+			break
+		}
+		posts = append(posts, *m)
+		from = m.ID
+	}
+
+	box := theme.Region("message")
+	entryH := box.H + 2 // the message box and its frame
+	scrn := NewScreen(bulletinWidth, 2+len(posts)*(entryH+1)+1)
+	frame, title := theme.Color("bulletin.frame"), theme.Color("bulletin.title")
+
+	scrn.PrintAt(3, 1, title+"Latest graffiti from the Toilet Stall"+Reset)
+	if len(posts) == 0 {
+		scrn.PrintAt(3, 3, theme.Color("message")+"The wall is clean. For now."+Reset)
+	}
+	y := 3
+	for _, m := range posts {
+		frame, color := frame, theme.Color("message")
+		if m.Pinned {
+			frame, color = theme.Color("pinned.border"), theme.Color("pinned")
+		}
+		scrn.PrintAt(3, y, frame+"┌"+strings.Repeat("─", box.W)+"┐"+Reset)
+		lines := formatMessage(&m, color, box.W, box.H)
+		for i := 0; i < box.H; i++ {
+			line := ""
+			if i < len(lines) {
+				line = lines[i]
+			}
+			line = layout.PadRight(line, box.W) // templates needn't fill the box
+			scrn.PrintAt(3, y+1+i, frame+"│"+Reset+color+line+Reset+frame+"│"+Reset)
+		}
+		scrn.PrintAt(3, y+entryH-1, frame+"└"+strings.Repeat("─", box.W)+"┘"+Reset)
+
+		x := box.W + 7
+		scrn.PrintAt(x, y+1, theme.Color("author")+"by "+m.Byline()+Reset)
+		scrn.PrintAt(x, y+2, theme.Color("thread")+formatDate(m.Posted)+Reset)
+		scrn.PrintAt(x, y+3, theme.Color("votes.keep")+fmt.Sprintf("+%d", m.Keep)+Reset+" "+
+			theme.Color("votes.flush")+fmt.Sprintf("-%d", m.Flush)+Reset)
+		if m.Replies == 1 {
+			scrn.PrintAt(x, y+4, theme.Color("thread")+"1 reply"+Reset)
+		} else if m.Replies > 1 {
+			scrn.PrintAt(x, y+4, theme.Color("thread")+fmt.Sprintf("%d replies", m.Replies)+Reset)
+		}
+		y += entryH + 1
+	}
+
+	var out bytes.Buffer
+	rows := screenCells(scrn, scrn.H)
+	if ascii {
+		writeASCIIRows(&out, rows)
+	} else {
+		writeANSIRows(&out, rows)
+	}
+	return out.Bytes(), nil
+}
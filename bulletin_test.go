@@ -0,0 +1,37 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBulletinPinnedFirst(t *testing.T) {
+	savedTheme := theme
+	t.Cleanup(func() { theme = savedTheme })
+	posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+	s, c := newTestStore(t, "flat",
+		Message{Body: "House rules: be nice", Author: "sysop", Posted: posted, Pinned: true},
+		Message{Body: "says who", Author: "j0HNNY", Posted: posted, Parent: 1},
+		Message{Body: "older scrawl", Author: "j0HNNY", Posted: posted},
+		Message{Body: "newest scrawl", Author: "aLPHA", Posted: posted},
+	)
+	var err error
+	if theme, err = LoadTheme(filepath.Join(c.ThemeDir, c.Theme)); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := renderBulletin(s, 2, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(data)
+	rules, newest := strings.Index(out, "House rules"), strings.Index(out, "newest scrawl")
+	if rules < 0 || newest < rules || strings.Contains(out, "older scrawl") {
+		t.Errorf("want the pinned post, then the newest one:\n%s", out)
+	}
+	if !strings.Contains(out, "1 reply") {
+		t.Errorf("the pinned post has no reply count:\n%s", out)
+	}
+}
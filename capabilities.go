@@ -0,0 +1,88 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Caps is what the caller's terminal owned up to when it was probed.
+type Caps struct {
+	Terminal  string // "SyncTERM" for CTerm replies, "VT" for other DA replies, "" for none
+	Version   string
+	Fonts     bool // can switch to the SAUCE font with CSI n;n SP D
+	ICEColors bool // can show bright backgrounds instead of blink
+	TrueColor bool // understands 24-bit SGR colors
+}
+
+var caps Caps
+
+// SyncTERM and its CTerm relatives answer DA with ESC [ = 67;84;101;114;109;v c,
+// which spells "CTerm" followed by the version. VT-style terminals answer
+// ESC [ ? ... c. Both probes end with a cursor position request: terminals
+// answer in order, so once the position report arrives anything that hasn't
+// answered by then isn't going to, and we don't have to sit out the timeout.
+var (
+	daReply      = regexp.MustCompile(`(?:\x1b\[([=?])([0-9;]*)c)?\x1b\[\d+;\d+R`)
+	decrqssReply = regexp.MustCompile(`(?:\x1bP1\$r([0-9;:]*)m\x1b\\)?\x1b\[\d+;\d+R`)
+)
+
+// ctermPrefix is "CTerm" as DA parameters.
+const ctermPrefix = "67;84;101;114;109"
+
+// probeColor is set and read back to see whether 24-bit color sticks.
+const probeColor = "12;34;56"
+
+// probeCaps asks the terminal what it is and what it can do, waiting up to
+// timeout for each answer. A terminal that ignores the questions gets the
+// zero Caps, which is plain 16-color ANSI.
+func probeCaps(t *Terminal, timeout time.Duration) Caps {
+	var c Caps
+
+	reply, ok := t.Query(Esc+"c"+Esc+"6n", daReply, timeout)
+	if !ok {
+		return c
+	}
+	switch params := reply[2]; {
+	case reply[1] == "=" && strings.HasPrefix(params, ctermPrefix):
+		c.Terminal = "SyncTERM"
+		c.Version = strings.TrimPrefix(strings.TrimPrefix(params, ctermPrefix), ";")
+		c.Fonts = true
+		c.ICEColors = true
+	case reply[1] != "":
+		c.Terminal = "VT"
+	default:
+		return c // no DA at all; don't push our luck with DCS strings
+	}
+
+	// Set a 24-bit color and ask for the current SGR with DECRQSS. Only a
+	// terminal that kept the color reports it back.
+	reply, ok = t.Query(Esc+"38;2;"+probeColor+"m"+"\x1bP$qm\x1b\\"+Reset+Esc+"6n", decrqssReply, timeout)
+	if ok {
+		sgr := strings.ReplaceAll(reply[1], ":", ";")
+		c.TrueColor = strings.Contains(sgr, probeColor)
+	}
+
+	// A terminal modern enough for 24-bit color has the aixterm bright
+	// backgrounds too.
+	if c.TrueColor {
+		c.ICEColors = true
+	}
+	return c
+}
+
+// sessionCaps works out the capabilities for this session: a probe for ANSI
+// callers, then the sysop's TrueColor setting on top.
+func sessionCaps(t *Terminal, cfg Config, emulation int) Caps {
+	var c Caps
+	if speaksANSI(emulation) {
+		c = probeCaps(t, cfg.ProbeTimeout)
+	}
+	switch cfg.TrueColor {
+	case "yes":
+		c.TrueColor = speaksANSI(emulation)
+	case "no":
+		c.TrueColor = false
+	}
+	return c
+}
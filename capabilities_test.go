@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+// answerProbe answers the capability probe as a terminal would: da to the
+// device attributes request, rqss to the SGR query, each followed by the
+// cursor position report that ends every probe.
+func answerProbe(da, rqss string) func(p []byte) []byte {
+	return func(p []byte) []byte {
+		switch {
+		case bytes.Contains(p, []byte("$qm")):
+			return []byte(rqss + Esc + "1;1R")
+		case bytes.Contains(p, []byte(Esc+"c")):
+			return []byte(da + Esc + "1;1R")
+		}
+		return nil
+	}
+}
+
+func TestProbeCaps(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer func(p []byte) []byte
+		want   Caps
+	}{
+		{"SyncTERM", answerProbe(Esc+"=67;84;101;114;109;1;316c", ""),
+			Caps{Terminal: "SyncTERM", Version: "1;316", Fonts: true, ICEColors: true}},
+		{"24-bit", answerProbe(Esc+"?62;22c", "\x1bP1$r0;38:2::12:34:56m\x1b\\"),
+			Caps{Terminal: "VT", ICEColors: true, TrueColor: true}},
+		{"24-bit with semicolons", answerProbe(Esc+"?1;2c", "\x1bP1$r0;38;2;12;34;56m\x1b\\"),
+			Caps{Terminal: "VT", ICEColors: true, TrueColor: true}},
+		{"color dropped", answerProbe(Esc+"?1;2c", "\x1bP1$r0m\x1b\\"), Caps{Terminal: "VT"}},
+		{"no DECRQSS", answerProbe(Esc+"?1;2c", ""), Caps{Terminal: "VT"}},
+		{"cursor report only", answerProbe("", ""), Caps{}},
+		{"silent", nil, Caps{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, c := newFakeClient(t, false, tt.answer)
+			start := time.Now()
+			if got := probeCaps(tm, 500*time.Millisecond); got != tt.want {
+				t.Errorf("got %+v, want %+v", got, tt.want)
+			}
+			if tt.answer != nil && time.Since(start) > 250*time.Millisecond {
+				t.Errorf("took %v with a terminal that answered everything", time.Since(start))
+			}
+			if tt.want.Terminal == "" && c.Sent("$qm") {
+				t.Error("sent DECRQSS to a terminal that didn't answer DA")
+			}
+		})
+	}
+}
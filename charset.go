@@ -0,0 +1,129 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"golang.org/x/text/encoding/charmap"
+)
+
+// Charset is the character set a caller's terminal speaks. Internally all
+// text is UTF-8; the terminal writer converts it on the way out and the key
+// reader converts input on the way in.
+type Charset int
+
+const (
+	CharsetCP437 Charset = iota
+	CharsetUTF8
+	CharsetASCII
+)
+
+func (c Charset) String() string {
+	switch c {
+	case CharsetUTF8:
+		return "utf8"
+	case CharsetASCII:
+		return "ascii"
+	default:
+		return "cp437"
+	}
+}
+
+// parseCharset maps a config value to a Charset.
+func parseCharset(s string) (Charset, error) {
+	switch strings.ToLower(s) {
+	case "cp437", "ibm", "ibmpc":
+		return CharsetCP437, nil
+	case "utf8", "utf-8":
+		return CharsetUTF8, nil
+	case "ascii", "7bit":
+		return CharsetASCII, nil
+	}
+	return CharsetCP437, fmt.Errorf("unknown charset %q", s)
+}
+
+// selectCharset picks the session charset. An explicit config setting wins;
+// otherwise local sessions get UTF-8, callers whose drop file reports plain
+// ASCII emulation get 7-bit output, and everyone else gets CP437.
+func selectCharset(setting string, emulation int, local bool) Charset {
+	if cs, err := parseCharset(setting); err == nil {
+		return cs
+	}
+	switch {
+	case local:
+		return CharsetUTF8
+	case emulation == 0:
+		return CharsetASCII
+	default:
+		return CharsetCP437
+	}
+}
+
+// encodeRune converts a rune to the byte sent to a CP437 or ASCII caller.
+func (c Charset) encodeRune(r rune) byte {
+	if r < 0x80 {
+		return byte(r)
+	}
+	if c == CharsetCP437 {
+		if b, ok := charmap.CodePage437.EncodeRune(r); ok {
+			return b
+		}
+	}
+	if b, ok := asciiFallback[r]; ok {
+		return b
+	}
+	return '?'
+}
+
+// decodeByte converts a high byte from a CP437 or ASCII caller to a rune.
+// ASCII callers shouldn't send these at all, but reading them as CP437 is
+// better than storing raw bytes.
+func (c Charset) decodeByte(b byte) rune {
+	return charmap.CodePage437.DecodeByte(b)
+}
+
+// decodeCP437 converts CP437 bytes, such as an art file, to UTF-8.
+func decodeCP437(b []byte) string {
+	var sb strings.Builder
+	sb.Grow(len(b))
+	for _, c := range b {
+		sb.WriteRune(charmap.CodePage437.DecodeByte(c))
+	}
+	return sb.String()
+}
+
+// asciiFallback approximates CP437 graphics and accented letters for callers
+// that can only display 7-bit ASCII.
+var asciiFallback = map[rune]byte{
+	// Shades and blocks
+	'░': '.', '▒': ':', '▓': '#', '█': '#',
+	'▄': '_', '▀': '"', '▌': '|', '▐': '|', '■': '#',
+
+	// Single and double lines
+	'─': '-', '━': '-', '═': '=',
+	'│': '|', '┃': '|', '║': '|',
+	'┌': '+', '┐': '+', '└': '+', '┘': '+',
+	'├': '+', '┤': '+', '┬': '+', '┴': '+', '┼': '+',
+	'╔': '+', '╗': '+', '╚': '+', '╝': '+',
+	'╠': '+', '╣': '+', '╦': '+', '╩': '+', '╬': '+',
+	'╒': '+', '╓': '+', '╕': '+', '╖': '+', '╘': '+', '╙': '+',
+	'╛': '+', '╜': '+', '╞': '+', '╟': '+', '╡': '+', '╢': '+',
+	'╤': '+', '╥': '+', '╧': '+', '╨': '+', '╪': '+', '╫': '+',
+
+	// Typography
+	'‘': '\'', '’': '\'', '“': '"', '”': '"', '–': '-', '—': '-',
+	'…': '.', '•': '*', '·': '.', '°': 'o', '«': '<', '»': '>',
+
+	// Accented letters
+	'á': 'a', 'à': 'a', 'â': 'a', 'ä': 'a', 'å': 'a', 'ã': 'a',
+	'Á': 'A', 'À': 'A', 'Â': 'A', 'Ä': 'A', 'Å': 'A', 'Ã': 'A',
+	'é': 'e', 'è': 'e', 'ê': 'e', 'ë': 'e',
+	'É': 'E', 'È': 'E', 'Ê': 'E', 'Ë': 'E',
+	'í': 'i', 'ì': 'i', 'î': 'i', 'ï': 'i',
+	'Í': 'I', 'Ì': 'I', 'Î': 'I', 'Ï': 'I',
+	'ó': 'o', 'ò': 'o', 'ô': 'o', 'ö': 'o', 'õ': 'o',
+	'Ó': 'O', 'Ò': 'O', 'Ô': 'O', 'Ö': 'O', 'Õ': 'O',
+	'ú': 'u', 'ù': 'u', 'û': 'u', 'ü': 'u',
+	'Ú': 'U', 'Ù': 'U', 'Û': 'U', 'Ü': 'U',
+	'ç': 'c', 'Ç': 'C', 'ñ': 'n', 'Ñ': 'N', 'ÿ': 'y',
+}
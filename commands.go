@@ -0,0 +1,28 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+// runCommand runs a sysop subcommand given on the command line instead of
+// the door, and returns the process exit code.
+func runCommand(args []string) int {
+	switch args[0] {
+	case "theme":
+		return themeCommand(args[1:])
+	case "admin":
+		return adminCommand(args[1:])
+	case "bulletin":
+		return bulletinCommand(args[1:])
+	case "export":
+		return exportCommand(args[1:])
+	case "net":
+		return netCommand(args[1:])
+	case "store":
+		return storeCommand(args[1:])
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n", args[0])
+	fmt.Fprintln(os.Stderr, "commands: admin, bulletin, export, net, theme, store")
+	return 2
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// Config holds the sysop's settings from the door's config file. The file
+// uses the same layout as the original TOILET1.CFG: one keyword per line,
+// followed by its values, with anything after a semi-colon ignored.
+type Config struct {
+	Charset        string // auto, cp437, utf8 or ascii
+	Theme          string
+	ThemeDir       string
+	ThemeSchedules []ThemeSchedule
+	ScreenWidth    int // used when the caller's size can't be detected
+	ScreenHeight   int
+	ProbeTimeout   time.Duration  // how long to wait for a terminal to answer
+	Telnet         string         // auto, yes or no
+	TrueColor      string         // auto, yes or no
+	StripColors    bool           // drop pipe codes from posts
+	FlushVotes     int            // flush votes that hide a message, 0 for never
+	TimeZone       *time.Location // zone times are shown in and old dates are read in, nil for the host's
+	DateFormat     string         // Go layout times are shown with
+	RelativeDates  bool           // recent times as "3h ago"
+	BrowsePinned   bool           // pinned posts turn up in N and P as well as on entry
+	Store          string         // flat or sqlite
+	StorePath      string         // the message file or database
+	Bulletin       string         // latest graffiti screen rewritten after posts, "" for none
+	BulletinCount  int            // posts on the bulletin
+	ExpireDays     int            // days before a post is hidden, 0 for never
+	ArchiveDays    int            // days after that before it's archived, 0 for never
+	NetBoard       string         // this board's name on the network
+	NetNode        string         // this board's node address
+	NetSecret      string         // key packets are signed with, shared by the network
+	NetInbound     string         // where other boards' packets arrive
+	NetOutbound    string         // where this board's packets go
+}
+
+// cfgLine is a single keyword and its values from a config-style file.
+type cfgLine struct {
+	Num     int
+	Keyword string
+	Args    []string
+}
+
+// defaultConfig returns the settings used when no config file exists.
+func defaultConfig() Config {
+	return Config{
+		Charset:  "auto",
+		Theme:    "classic",
+		ThemeDir: "themes",
+
+		ScreenWidth:  80,
+		ScreenHeight: 25,
+		ProbeTimeout: 2 * time.Second,
+		Telnet:       "auto",
+		TrueColor:    "auto",
+		FlushVotes:   3,
+		DateFormat:   legacyLayout,
+		Store:        "flat",
+		StorePath:    "messages.txt",
+
+		BulletinCount: 5,
+	}
+}
+
+// loadConfig reads the config file at path. A missing file is not an error;
+// the door just runs with the defaults.
+func loadConfig(path string) (Config, error) {
+	cfg := defaultConfig()
+
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return cfg, nil
+	}
+	if err != nil {
+		return cfg, err
+	}
+	defer file.Close()
+
+	lines, err := readCfgLines(file)
+	if err != nil {
+		return cfg, err
+	}
+
+	for _, line := range lines {
+		if err := cfg.apply(line); err != nil {
+			return cfg, fmt.Errorf("%s:%d: %v", path, line.Num, err)
+		}
+	}
+	return cfg, nil
+}
+
+// apply sets the option named by a single config line.
+func (cfg *Config) apply(line cfgLine) error {
+	switch strings.ToLower(line.Keyword) {
+	case "charset":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("Charset takes one value")
+		}
+		if _, err := parseCharset(line.Args[0]); err != nil && !strings.EqualFold(line.Args[0], "auto") {
+			return err
+		}
+		cfg.Charset = strings.ToLower(line.Args[0])
+	case "theme":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("Theme takes one theme name")
+		}
+		cfg.Theme = line.Args[0]
+	case "themedir":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("ThemeDir takes one directory")
+		}
+		cfg.ThemeDir = line.Args[0]
+	case "themeschedule":
+		if len(line.Args) != 3 {
+			return fmt.Errorf("ThemeSchedule takes a start, an end and a theme name")
+		}
+		from, err := parseScheduleBound(line.Args[0])
+		if err != nil {
+			return err
+		}
+		to, err := parseScheduleBound(line.Args[1])
+		if err != nil {
+			return err
+		}
+		if strings.Contains(from, ":") != strings.Contains(to, ":") {
+			return fmt.Errorf("ThemeSchedule can't mix dates and times")
+		}
+		cfg.ThemeSchedules = append(cfg.ThemeSchedules, ThemeSchedule{From: from, To: to, Theme: line.Args[2]})
+	case "screensize":
+		if len(line.Args) != 2 {
+			return fmt.Errorf("ScreenSize takes a width and a height")
+		}
+		nums, err := atois(line.Args)
+		if err != nil {
+			return err
+		}
+		if nums[0] < 40 || nums[1] < 20 {
+			return fmt.Errorf("ScreenSize must be at least 40 x 20")
+		}
+		cfg.ScreenWidth, cfg.ScreenHeight = nums[0], nums[1]
+	case "probetimeout":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("ProbeTimeout takes one duration, like 2s or 500ms")
+		}
+		d, err := time.ParseDuration(line.Args[0])
+		if err != nil {
+			return err
+		}
+		cfg.ProbeTimeout = d
+	case "telnet":
+		v, err := autoYesNo(line)
+		if err != nil {
+			return err
+		}
+		cfg.Telnet = v
+	case "flushvotes":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("FlushVotes takes a number of votes")
+		}
+		nums, err := atois(line.Args)
+		if err != nil {
+			return err
+		}
+		if nums[0] < 0 {
+			return fmt.Errorf("FlushVotes can't be negative")
+		}
+		cfg.FlushVotes = nums[0]
+	case "stripcolors":
+		v, err := autoYesNo(line)
+		if err != nil || v == "auto" {
+			return fmt.Errorf("StripColors takes yes or no")
+		}
+		cfg.StripColors = v == "yes"
+	case "timezone":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("TimeZone takes a zone name, like America/New_York or UTC")
+		}
+		loc, err := time.LoadLocation(line.Args[0])
+		if err != nil {
+			return fmt.Errorf("TimeZone: %v", err)
+		}
+		cfg.TimeZone = loc
+	case "dateformat":
+		layout, err := parseDateFormat(line.Args)
+		if err != nil {
+			return err
+		}
+		cfg.DateFormat = layout
+	case "relativedates":
+		v, err := autoYesNo(line)
+		if err != nil || v == "auto" {
+			return fmt.Errorf("RelativeDates takes yes or no")
+		}
+		cfg.RelativeDates = v == "yes"
+	case "browsepinned":
+		v, err := autoYesNo(line)
+		if err != nil || v == "auto" {
+			return fmt.Errorf("BrowsePinned takes yes or no")
+		}
+		cfg.BrowsePinned = v == "yes"
+	case "store":
+		if len(line.Args) != 2 {
+			return fmt.Errorf("Store takes flat or sqlite and a file")
+		}
+		switch v := strings.ToLower(line.Args[0]); v {
+		case "flat", "sqlite":
+			cfg.Store, cfg.StorePath = v, line.Args[1]
+		default:
+			return fmt.Errorf("Store takes flat or sqlite, not %q", line.Args[0])
+		}
+	case "expire":
+		if len(line.Args) < 1 || len(line.Args) > 2 {
+			return fmt.Errorf("Expire takes the days before posts are hidden and, optionally, the days after that before they're archived")
+		}
+		nums, err := atois(line.Args)
+		if err != nil {
+			return err
+		}
+		for _, n := range nums {
+			if n < 0 {
+				return fmt.Errorf("Expire can't be negative")
+			}
+		}
+		cfg.ExpireDays = nums[0]
+		if len(nums) == 2 {
+			cfg.ArchiveDays = nums[1]
+		}
+	case "bulletin":
+		if len(line.Args) < 1 || len(line.Args) > 2 {
+			return fmt.Errorf("Bulletin takes a file and, optionally, a number of posts")
+		}
+		cfg.Bulletin = line.Args[0]
+		if len(line.Args) == 2 {
+			nums, err := atois(line.Args[1:])
+			if err != nil {
+				return err
+			}
+			if nums[0] < 1 {
+				return fmt.Errorf("Bulletin needs at least one post")
+			}
+			cfg.BulletinCount = nums[0]
+		}
+	case "netboard":
+		if len(line.Args) < 1 {
+			return fmt.Errorf("NetBoard takes the board's name")
+		}
+		cfg.NetBoard = strings.Join(line.Args, " ")
+	case "netnode":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("NetNode takes one node address")
+		}
+		cfg.NetNode = line.Args[0]
+	case "netsecret":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("NetSecret takes one key")
+		}
+		cfg.NetSecret = line.Args[0]
+	case "netinbound":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("NetInbound takes one directory")
+		}
+		cfg.NetInbound = line.Args[0]
+	case "netoutbound":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("NetOutbound takes one directory")
+		}
+		cfg.NetOutbound = line.Args[0]
+	case "truecolor":
+		v, err := autoYesNo(line)
+		if err != nil {
+			return err
+		}
+		cfg.TrueColor = v
+	default:
+		return fmt.Errorf("unknown keyword %q", line.Keyword)
+	}
+	return nil
+}
+
+// autoYesNo reads the single auto, yes or no value of a config line.
+func autoYesNo(line cfgLine) (string, error) {
+	if len(line.Args) == 1 {
+		switch v := strings.ToLower(line.Args[0]); v {
+		case "auto", "yes", "no":
+			return v, nil
+		}
+	}
+	return "", fmt.Errorf("%s takes auto, yes or no", line.Keyword)
+}
+
+// readCfgLines splits a config-style file into keyword lines, dropping
+// comments and blank lines.
+func readCfgLines(r io.Reader) ([]cfgLine, error) {
+	var lines []cfgLine
+
+	scanner := bufio.NewScanner(r)
+	num := 0
+	for scanner.Scan() {
+		num++
+		text := scanner.Text()
+		if idx := strings.Index(text, ";"); idx != -1 {
+			text = text[:idx]
+		}
+		fields := strings.Fields(text)
+		if len(fields) == 0 {
+			continue
+		}
+		lines = append(lines, cfgLine{Num: num, Keyword: fields[0], Args: fields[1:]})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return lines, nil
+}
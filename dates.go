@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Times are stored as RFC 3339 in UTC, so every node and host agrees on
+// them and they sort as text. How they're shown is up to the board: in its
+// TimeZone, with its DateFormat, and as "3h ago" for the last week when
+// RelativeDates is on. Message files from before that kept local times as
+// legacyLayout; those are read as the board's TimeZone.
+
+// legacyLayout is how older versions of the door wrote post times.
+const legacyLayout = "01/02/06 03:04PM"
+
+// relativeLimit is how far back RelativeDates shows times as "ago".
+const relativeLimit = 7 * 24 * time.Hour
+
+// storeTime writes a time for the message file or the database.
+func storeTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339)
+}
+
+// parseStoredTime reads a time written by storeTime, or by an older door in
+// the board's zone. A time that doesn't parse comes back zero.
+func parseStoredTime(value string) time.Time {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t.UTC()
+	}
+	if t, err := time.ParseInLocation(legacyLayout, value, cfg.location()); err == nil {
+		return t.UTC()
+	}
+	return time.Time{}
+}
+
+// location is the board's zone, the host's when it isn't set.
+func (c *Config) location() *time.Location {
+	if c.TimeZone != nil {
+		return c.TimeZone
+	}
+	return time.Local
+}
+
+// showTime formats a time for callers and the sysop the way the board
+// wants it, relative to now when RelativeDates is on and it's recent.
+func showTime(t, now time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	if cfg.RelativeDates {
+		if ago := now.Sub(t); ago >= -time.Minute && ago < relativeLimit {
+			return relativeTime(ago)
+		}
+	}
+	return formatDate(t)
+}
+
+// formatDate formats a time with the board's DateFormat in its zone. Files
+// that sit around, like exports and the bulletin, use it rather than
+// showTime so they don't go stale.
+func formatDate(t time.Time) string {
+	if t.IsZero() {
+		return "?"
+	}
+	layout := cfg.DateFormat
+	if layout == "" {
+		layout = legacyLayout
+	}
+	return t.In(cfg.location()).Format(layout)
+}
+
+// relativeTime says how long ago something was, to the largest whole unit.
+func relativeTime(ago time.Duration) string {
+	switch {
+	case ago < time.Minute:
+		return "just now"
+	case ago < time.Hour:
+		return fmt.Sprintf("%dm ago", int(ago/time.Minute))
+	case ago < 24*time.Hour:
+		return fmt.Sprintf("%dh ago", int(ago/time.Hour))
+	}
+	return fmt.Sprintf("%dd ago", int(ago/(24*time.Hour)))
+}
+
+// parseDateFormat reads a DateFormat: a Go layout, written with the parts
+// of Mon Jan 2 15:04:05 2006, which has to show at least the day.
+func parseDateFormat(args []string) (string, error) {
+	layout := strings.Join(args, " ")
+	probe := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	if layout == "" || probe.Format(layout) == probe.AddDate(0, 0, 1).Format(layout) {
+		return "", fmt.Errorf("DateFormat needs a layout that shows the date, like Jan 2 2006 3:04PM")
+	}
+	return layout, nil
+}
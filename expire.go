@@ -0,0 +1,125 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Graffiti fades. With an Expire rule in the config file, posts are hidden
+// a number of days after they were written and, if the rule says so, moved
+// out of the store into an archive file some days after that. A message
+// can be given its own expiry date, and pinned messages never expire.
+// Messages whose date didn't parse are left alone.
+
+// cleanup is what a maintenance run did.
+type cleanup struct {
+	Hidden   int // messages that expired
+	Archived int // messages moved to the archive, replies included
+}
+
+func (c cleanup) changed() bool {
+	return c.Hidden > 0 || c.Archived > 0
+}
+
+// expiry returns when m is due to be hidden and when it's due to be
+// archived. Zero times mean never.
+func expiry(m *Message, c Config) (hide, archive time.Time) {
+	switch {
+	case m.Pinned:
+		return
+	case !m.Expires.IsZero():
+		hide = m.Expires
+	case c.ExpireDays > 0 && !m.Posted.IsZero():
+		hide = m.Posted.AddDate(0, 0, c.ExpireDays)
+	default:
+		return
+	}
+	if c.ArchiveDays > 0 {
+		archive = hide.AddDate(0, 0, c.ArchiveDays)
+	}
+	return hide, archive
+}
+
+// maintain applies the expiry rules as they stand at now: it hides the
+// messages that have expired, archives the ones due to go, with their
+// replies, and compacts the store if anything went. Nodes starting at the
+// same moment take turns.
+func maintain(s Store, c Config, now time.Time) (cleanup, error) {
+	var done cleanup
+	unlock, err := lockFile(c.StorePath + ".maint")
+	if err != nil {
+		return done, err
+	}
+	defer unlock()
+
+	msgs, err := s.Messages()
+	if err != nil {
+		return done, err
+	}
+	due := map[int]bool{}
+	for i := range msgs {
+		if _, archive := expiry(&msgs[i], c); !archive.IsZero() && !now.Before(archive) {
+			due[msgs[i].ID] = true
+		}
+	}
+
+	gone := map[int]bool{} // replies archived with their posts
+	for i := range msgs {
+		m := &msgs[i]
+		if gone[m.ID] {
+			continue
+		}
+		hide, _ := expiry(m, c)
+		switch {
+		case due[m.ID]:
+			// Into the archive first, so nothing is lost if deleting fails
+			lines := []string{formatRecord(m)}
+			for j := range msgs {
+				if msgs[j].Parent == m.ID {
+					lines = append(lines, formatRecord(&msgs[j]))
+					gone[msgs[j].ID] = true
+				}
+			}
+			for _, line := range lines {
+				if err := appendLine(c.StorePath+".archive", line); err != nil {
+					return done, err
+				}
+			}
+			n, err := s.Delete(m.ID)
+			if err != nil {
+				return done, err
+			}
+			done.Archived += n
+		case !hide.IsZero() && !now.Before(hide) && !m.Hidden:
+			if _, err := s.Hide(m.ID, true); err != nil {
+				return done, err
+			}
+			done.Hidden++
+		}
+	}
+
+	if done.Archived > 0 {
+		if err := s.Compact(); err != nil {
+			return done, err
+		}
+	}
+	return done, nil
+}
+
+// parseExpiry reads when a message should expire, as given to admin
+// expire: a number of days from now, a date, or "default" to follow the
+// wall's rule again.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	if strings.EqualFold(value, "default") {
+		return time.Time{}, nil
+	}
+	if days, err := strconv.Atoi(strings.TrimSuffix(strings.ToLower(value), "d")); err == nil {
+		if days < 0 {
+			return time.Time{}, fmt.Errorf("can't expire %d days ago", -days)
+		}
+		return now.AddDate(0, 0, days), nil
+	}
+	return parseSearchDate(value)
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The expiry tests run maintenance at made-up times rather than waiting,
+// against both stores.
+
+var wallStart = time.Date(2024, 1, 1, 12, 0, 0, 0, time.Local)
+
+func maintainAt(t *testing.T, s Store, c Config, now time.Time, hidden, archived int) {
+	t.Helper()
+	done, err := maintain(s, c, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if done.Hidden != hidden || done.Archived != archived {
+		t.Fatalf("at %s hid %d and archived %d, want %d and %d",
+			now.Format("2006-01-02"), done.Hidden, done.Archived, hidden, archived)
+	}
+}
+
+func TestExpireHidesThenArchives(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, c Config) {
+		c.ExpireDays, c.ArchiveDays = 30, 10
+		old := postAt(t, s, "old news", wallStart, 0)
+		postAt(t, s, "reply to old news", wallStart.AddDate(0, 0, 35), old.ID)
+		postAt(t, s, "fresh", wallStart.AddDate(0, 0, 20), 0)
+
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 29), 0, 0)
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 30), 1, 0)
+		if m, _, _ := s.Get(old.ID); !m.Hidden {
+			t.Errorf("post wasn't hidden after 30 days")
+		}
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 31), 0, 0) // already hidden
+
+		// The reply isn't due yet, but it goes with its post
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 40), 0, 2)
+		msgs, err := s.Messages()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(msgs) != 1 || msgs[0].Body != "fresh" {
+			t.Errorf("left %v on the wall, want only the fresh post", msgs)
+		}
+
+		data, err := os.ReadFile(c.StorePath + ".archive")
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(lines) != 2 {
+			t.Fatalf("archive has %d lines, want 2", len(lines))
+		}
+		for i, want := range []string{"old news", "reply to old news"} {
+			if m := parseRecord(lines[i]); m.Body != want {
+				t.Errorf("archive line %d is %q, want %q", i+1, lines[i], want)
+			}
+		}
+	})
+}
+
+func TestPinnedNeverExpires(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, c Config) {
+		c.ExpireDays, c.ArchiveDays = 30, 10
+		m := postAt(t, s, "house rules", wallStart, 0)
+		if _, err := s.Change(m.ID, func(m *Message) { m.Pinned = true }); err != nil {
+			t.Fatal(err)
+		}
+		maintainAt(t, s, c, wallStart.AddDate(5, 0, 0), 0, 0)
+		got, ok, err := s.Get(m.ID)
+		if err != nil || !ok || got.Hidden || !got.Pinned {
+			t.Errorf("pinned post came back as %+v, %v, %v", got, ok, err)
+		}
+	})
+}
+
+func TestMessageExpiry(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, c Config) {
+		c.ArchiveDays = 10 // and ExpireDays 0: posts stay unless they say otherwise
+		soon := postAt(t, s, "sale ends friday", wallStart, 0)
+		postAt(t, s, "forever", wallStart, 0)
+		expires, err := parseExpiry("3d", wallStart)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.Change(soon.ID, func(m *Message) { m.Expires = expires }); err != nil {
+			t.Fatal(err)
+		}
+		if got, _, _ := s.Get(soon.ID); !got.Expires.Equal(expires) {
+			t.Fatalf("expiry saved as %v, want %v", got.Expires, expires)
+		}
+
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 2), 0, 0)
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 3), 1, 0)
+		maintainAt(t, s, c, wallStart.AddDate(0, 0, 13), 0, 1)
+		maintainAt(t, s, c, wallStart.AddDate(10, 0, 0), 0, 0)
+	})
+}
@@ -0,0 +1,402 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// exportFormats are the formats export can write, by name and by the file
+// extension that picks them.
+var exportFormats = map[string]string{
+	"ans": "ans", "ansi": "ans",
+	"txt": "txt", "text": "txt", "asc": "txt",
+	"html": "html", "htm": "html",
+	"json": "json",
+}
+
+// exportOptions is what the export command was asked for.
+type exportOptions struct {
+	Format  string
+	Filter  Search
+	Replies bool // include replies as well as posts
+	Limit   int  // only the newest posts, 0 for all of them
+	Font    string
+}
+
+// exportCommand handles "toilet-redux export", which renders posts from the
+// wall for web sites, bulletins and other boards. It is meant to be run by
+// hand or from cron.
+func exportCommand(args []string) int {
+	fs := flag.NewFlagSet("export", flag.ContinueOnError)
+	format := fs.String("format", "", "ans, txt, html or json (default: from the -o file name, else txt)")
+	outPath := fs.String("o", "", "file to write (default: standard output)")
+	by := fs.String("by", "", "only posts by this alias; anonymous posts only match \"anonymous\"")
+	after := fs.String("after", "", "only posts from this date on")
+	before := fs.String("before", "", "only posts up to the end of this date")
+	days := fs.Int("days", 0, "only posts from the last n days")
+	query := fs.String("search", "", "only posts matching a search, as typed at the S prompt")
+	replies := fs.Bool("replies", false, "include replies")
+	limit := fs.Int("limit", 0, "only the newest n posts")
+	font := fs.String("font", "", "URL of a CP437 web font for the html format")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(os.Stderr, "export: unexpected %q\n", fs.Arg(0))
+		return 2
+	}
+
+	opts := exportOptions{Replies: *replies, Limit: *limit, Font: *font}
+	name := strings.ToLower(*format)
+	if name == "" && *outPath != "" {
+		name = strings.TrimPrefix(strings.ToLower(filepath.Ext(*outPath)), ".")
+	}
+	if name == "" {
+		name = "txt"
+	}
+	var ok bool
+	if opts.Format, ok = exportFormats[name]; !ok {
+		fmt.Fprintf(os.Stderr, "export: unknown format %q, want ans, txt, html or json\n", name)
+		return 2
+	}
+
+	if err := opts.parseFilter(*query, *by, *after, *before, *days); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 2
+	}
+
+	s, err := openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+	theme = selectTheme(cfg, time.Now())
+
+	var out bytes.Buffer
+	if err := exportWall(&out, s, opts); err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	if *outPath == "" {
+		_, err = os.Stdout.Write(out.Bytes())
+	} else {
+		err = os.WriteFile(*outPath, out.Bytes(), 0644)
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "export: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// parseFilter builds the export's filter from a search query and the
+// filter flags, which win over the query.
+func (o *exportOptions) parseFilter(query, by, after, before string, days int) error {
+	if strings.TrimSpace(query) != "" {
+		s, err := parseSearch(query)
+		if err != nil {
+			return err
+		}
+		o.Filter = *s
+	}
+	if by != "" {
+		o.Filter.Author = by
+	}
+	if after != "" {
+		d, err := parseSearchDate(after)
+		if err != nil {
+			return err
+		}
+		o.Filter.After = d
+	}
+	if before != "" {
+		d, err := parseSearchDate(before)
+		if err != nil {
+			return err
+		}
+		o.Filter.Before = d.AddDate(0, 0, 1)
+	}
+	if days > 0 {
+		o.Filter.After = time.Now().AddDate(0, 0, -days)
+	}
+	return nil
+}
+
+// exportWall writes the posts the options select in their format.
+func exportWall(w io.Writer, s Store, opts exportOptions) error {
+	msgs, err := s.Messages()
+	if err != nil {
+		return err
+	}
+	msgs = selectExport(msgs, opts)
+
+	switch opts.Format {
+	case "ans":
+		return exportANSI(w, msgs)
+	case "html":
+		return exportHTML(w, msgs, opts.Font)
+	case "json":
+		return exportJSON(w, msgs)
+	default:
+		return exportText(w, msgs)
+	}
+}
+
+// selectExport picks the visible messages that pass the options' filter,
+// oldest first, with their reply counts.
+func selectExport(msgs []Message, opts exportOptions) []Message {
+	replies := map[int]int{}
+	for _, m := range msgs {
+		if !m.Hidden && m.Parent != 0 {
+			replies[m.Parent]++
+		}
+	}
+	var picked []Message
+	for _, m := range msgs {
+		if m.Hidden || (m.Parent != 0 && !opts.Replies) || !opts.Filter.Matches(&m) {
+			continue
+		}
+		m.Replies = replies[m.ID]
+		picked = append(picked, m)
+	}
+	if opts.Limit > 0 && len(picked) > opts.Limit {
+		picked = picked[len(picked)-opts.Limit:]
+	}
+	return picked
+}
+
+// drawCard draws a message on the stall art in the screen buffer, the way
+// callers see it but without the menu and status bar, and returns the rows.
+func drawCard(art *Art, m *Message) [][]Cell {
+	drawArt(art)
+	drawPost(m)
+	drawByline(m)
+	thread := formatDate(m.Posted)
+	if m.Parent != 0 {
+		thread = fmt.Sprintf("%s, reply to #%d", thread, m.Parent)
+	}
+	drawThread(thread)
+
+	h := art.Sauce.Height()
+	if h <= 0 || h > scr.H {
+		h = scr.H
+	}
+	return screenCells(scr, h)
+}
+
+// screenCells copies the top h rows of a screen buffer.
+func screenCells(s *Screen, h int) [][]Cell {
+	rows := make([][]Cell, h)
+	for y := range rows {
+		rows[y] = make([]Cell, s.W)
+		for x := range rows[y] {
+			rows[y][x] = s.Cell(x+1, y+1)
+		}
+	}
+	return rows
+}
+
+// writeANSIRows writes rows of cells as CP437 ANSI text, a line to a row.
+// Trailing blanks are left off, so rows don't wrap early.
+func writeANSIRows(w *bytes.Buffer, rows [][]Cell) {
+	out := ansiBackend{}
+	for _, row := range rows {
+		row = trimBlankCells(row)
+		var pen Cell
+		for x, c := range row {
+			if x == 0 || c.Attr != pen.Attr {
+				out.SetAttr(w, c.Attr, 0, 0)
+				pen = c
+			}
+			w.WriteByte(CharsetCP437.encodeRune(c.Ch))
+		}
+		w.WriteString(Reset + "\r\n")
+	}
+}
+
+// writeASCIIRows writes rows of cells as plain 7-bit text, with the art's
+// blocks and lines approximated.
+func writeASCIIRows(w *bytes.Buffer, rows [][]Cell) {
+	for _, row := range rows {
+		row = trimBlankCells(row)
+		for len(row) > 0 && row[len(row)-1].Ch == ' ' {
+			row = row[:len(row)-1]
+		}
+		for _, c := range row {
+			w.WriteByte(CharsetASCII.encodeRune(c.Ch))
+		}
+		w.WriteString("\r\n")
+	}
+}
+
+func trimBlankCells(row []Cell) []Cell {
+	for len(row) > 0 && row[len(row)-1] == blankCell {
+		row = row[:len(row)-1]
+	}
+	return row
+}
+
+// exportANSI writes each message as a card on the stall art, one under the
+// other, in CP437 with a SAUCE record, ready for an ANSI viewer or another
+// board's bulletins.
+func exportANSI(w io.Writer, msgs []Message) error {
+	art, err := ReadArt(theme.ArtPath())
+	if err != nil {
+		return err
+	}
+	var data bytes.Buffer
+	lines := 0
+	for i := range msgs {
+		rows := drawCard(art, &msgs[i])
+		writeANSIRows(&data, rows)
+		lines += len(rows)
+	}
+
+	sauce := &Sauce{
+		Title:    "Toilet Stall Redux",
+		Date:     time.Now().Format("20060102"),
+		DataType: sauceDataCharacter,
+		FileType: sauceFileANSi,
+		TInfo1:   uint16(scr.W),
+		TInfo2:   uint16(lines),
+		Comments: []string{fmt.Sprintf("%d posts from the wall", len(msgs))},
+	}
+	if art.Sauce != nil {
+		sauce.Flags = art.Sauce.Flags // iCE colors and letter spacing
+		sauce.Font = art.Sauce.Font
+	}
+	_, err = w.Write(AppendSauce(data.Bytes(), sauce))
+	return err
+}
+
+// exportText writes the messages as plain text for bulletins: a heading
+// line for each, then the message wrapped and indented, without colors.
+func exportText(w io.Writer, msgs []Message) error {
+	var out bytes.Buffer
+	for _, m := range msgs {
+		fmt.Fprintf(&out, "%s  %s  +%d -%d", formatDate(m.Posted), m.Byline(), m.Keep, m.Flush)
+		if m.Parent != 0 {
+			fmt.Fprintf(&out, "  (reply to #%d)", m.Parent)
+		}
+		out.WriteString("\n")
+		for _, line := range layout.Wrap(stripMarkup(m.Body), 74) {
+			out.WriteString("    " + line + "\n")
+		}
+		out.WriteString("\n")
+	}
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// exportHTML writes a web page with each message as a card on the stall
+// art, in the VGA palette. Without a font URL the page asks for the usual
+// CP437 web fonts by name and falls back to the browser's monospace font.
+func exportHTML(w io.Writer, msgs []Message, font string) error {
+	art, err := ReadArt(theme.ArtPath())
+	if err != nil {
+		return err
+	}
+	ice := art.Sauce.ICEColors()
+
+	var out bytes.Buffer
+	out.WriteString("<!DOCTYPE html>\n<html>\n<head>\n<meta charset=\"utf-8\">\n<title>Toilet Stall Redux</title>\n<style>\n")
+	if font != "" {
+		fmt.Fprintf(&out, "@font-face { font-family: \"WallFont\"; src: url(%q); }\n", font)
+	}
+	out.WriteString("body { background: #000; color: #aaa; }\n")
+	out.WriteString(".card { font-family: \"WallFont\", \"Px437 IBM VGA 8x16\", \"Px437 IBM VGA8\", \"Perfect DOS VGA 437\", monospace;" +
+		" font-size: 16px; line-height: 1; margin: 0 auto 2em; width: max-content; }\n")
+	out.WriteString("</style>\n</head>\n<body>\n")
+
+	for i := range msgs {
+		m := &msgs[i]
+		fmt.Fprintf(&out, "<pre class=\"card\" id=\"post-%d\" title=\"%s\">", m.ID, html.EscapeString(m.Byline()+", "+formatDate(m.Posted)))
+		for _, row := range drawCard(art, m) {
+			var pen string
+			for x, c := range row {
+				if style := cellStyle(c, ice); x == 0 || style != pen {
+					if x > 0 {
+						out.WriteString("</span>")
+					}
+					fmt.Fprintf(&out, "<span style=\"%s\">", style)
+					pen = style
+				}
+				out.WriteString(html.EscapeString(string(c.Ch)))
+			}
+			out.WriteString("</span>\n")
+		}
+		out.WriteString("</pre>\n")
+	}
+	out.WriteString("</body>\n</html>\n")
+	_, err = w.Write(out.Bytes())
+	return err
+}
+
+// jsonPost is a message as the json format publishes it. Anonymous posts
+// leave the author out, and unset fields are left out rather than zero.
+type jsonPost struct {
+	ID         int        `json:"id"`
+	Body       string     `json:"body"`
+	Author     string     `json:"author,omitempty"`
+	Anonymous  bool       `json:"anonymous"`
+	Posted     time.Time  `json:"posted"`
+	Flush      int        `json:"flush"`
+	Keep       int        `json:"keep"`
+	Parent     int        `json:"parent,omitempty"`
+	Replies    int        `json:"replies"`
+	Pinned     bool       `json:"pinned,omitempty"`
+	Expires    *time.Time `json:"expires,omitempty"`
+	Origin     string     `json:"origin,omitempty"`
+	OriginNode string     `json:"origin_node,omitempty"`
+	MsgID      string     `json:"msgid,omitempty"`
+}
+
+// exportJSON writes the messages as a JSON array for other tools.
+func exportJSON(w io.Writer, msgs []Message) error {
+	posts := make([]jsonPost, len(msgs))
+	for i, m := range msgs {
+		posts[i] = jsonPost{
+			ID: m.ID, Body: m.Body, Author: m.Author, Anonymous: m.Anonymous,
+			Posted: m.Posted, Flush: m.Flush, Keep: m.Keep, Parent: m.Parent,
+			Replies: m.Replies, Pinned: m.Pinned,
+			Origin: m.Origin, OriginNode: m.OriginNode, MsgID: m.MsgID,
+		}
+		if m.Anonymous {
+			posts[i].Author = ""
+		}
+		if !m.Expires.IsZero() {
+			expires := m.Expires
+			posts[i].Expires = &expires
+		}
+	}
+	return writeJSON(w, posts)
+}
+
+// cellStyle is the CSS for a cell's colors: 24-bit colors as given, PC
+// colors from the VGA palette. The blink bit is a bright background with
+// iCE colors and is otherwise left out.
+func cellStyle(c Cell, ice bool) string {
+	fg := pcPalette[c.Attr.fg()]
+	if c.Fg&rgbSet != 0 {
+		fg = c.Fg
+	}
+	bgIndex := c.Attr.bg()
+	if ice && c.Attr&attrBlink != 0 {
+		bgIndex += 8
+	}
+	bg := pcPalette[bgIndex]
+	if c.Bg&rgbSet != 0 {
+		bg = c.Bg
+	}
+	return fmt.Sprintf("color:#%06x;background:#%06x", uint32(fg)&0xffffff, uint32(bg)&0xffffff)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExportJSONKeepsAnonymity(t *testing.T) {
+	posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+	s, _ := newTestStore(t, "flat",
+		Message{Body: "signed", Author: "Rob", Posted: posted},
+		Message{Body: "unsigned", Author: "Secret Sam", Anonymous: true, Posted: posted},
+	)
+	var out bytes.Buffer
+	if err := exportWall(&out, s, exportOptions{Format: "json"}); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(out.String(), "Secret Sam") {
+		t.Errorf("the export names an anonymous poster:\n%s", out.String())
+	}
+	if strings.Contains(out.String(), "expires") {
+		t.Errorf("the export has an expiry for posts that don't have one:\n%s", out.String())
+	}
+
+	var posts []map[string]interface{}
+	if err := json.Unmarshal(out.Bytes(), &posts); err != nil {
+		t.Fatal(err)
+	}
+	if len(posts) != 2 || posts[0]["author"] != "Rob" || posts[1]["author"] != nil || posts[1]["anonymous"] != true {
+		t.Errorf("got %v", posts)
+	}
+}
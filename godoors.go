@@ -5,16 +5,13 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
-	"regexp"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unicode/utf8"
 
 	"github.com/eiannone/keyboard"
-	"golang.org/x/text/encoding/charmap"
+	"github.com/robbiew/toilet-redux/layout"
 )
 
 // TimerManager manages timers for idle and max timeout
@@ -71,6 +68,9 @@ const (
 	CursorBlinkEnable  = Esc + "?12h"
 	CursorBlinkDisable = Esc + "?12I"
 
+	ICEColorsEnable  = Esc + "?33h" // bright backgrounds instead of blink
+	ICEColorsDisable = Esc + "?33l"
+
 	ScrollUp   = Esc + "S"
 	ScrollDown = Esc + "T"
 
@@ -129,8 +129,11 @@ var Idle int
 // Get info from the Drop File, h, w
 func Initialize(path string) User {
 
-	alias, timeLeft, emulation, nodeNum := DropFileData(path)
-	h, w := GetTermSize()
+	alias, userNum, timeLeft, emulation, nodeNum := DropFileData(path)
+
+	// The cursor position probe is ANSI, which ASCII and Avatar callers
+	// would just see as garbage
+	w, h, _ := detectTermSize(term, int(os.Stdin.Fd()), cfg, speaksANSI(emulation))
 
 	if h%2 == 0 {
 		modalH = h
@@ -148,6 +151,7 @@ func Initialize(path string) User {
 
 	u := User{
 		Alias:     alias,
+		UserNum:   userNum,
 		TimeLeft:  timeLeftDuration,
 		Emulation: emulation,
 		NodeNum:   nodeNum,
@@ -162,7 +166,7 @@ func Initialize(path string) User {
 // Continue Y/N
 func Continue() bool {
 
-	char, key, err := keyboard.GetKey()
+	char, key, err := term.GetKey()
 	if err != nil {
 		panic(err)
 	}
@@ -181,7 +185,7 @@ func Pause(h int, w int) {
 
 	CenterText("Press any key to continue...", w)
 
-	_, _, err := keyboard.GetKey()
+	_, _, err := term.GetKey()
 	if err != nil {
 		panic(err)
 	}
@@ -189,72 +193,73 @@ func Pause(h int, w int) {
 
 // Move cursor to X, Y location
 func MoveCursor(x int, y int) {
-	fmt.Printf(Esc+"%d;%df", y, x)
+	fmt.Fprintf(term, Esc+"%d;%df", y, x)
 }
 
 // Erase the screen
 func ClearScreen() {
-	fmt.Println(EraseScreen)
+	fmt.Fprintln(term, EraseScreen)
 	MoveCursor(0, 0)
 }
 
 // Move the cursor n cells to up.
 func CursorUp(n int) {
-	fmt.Printf(Esc+"%dA", n)
+	fmt.Fprintf(term, Esc+"%dA", n)
 }
 
 // Move the cursor n cells to down.
 func CursorDown(n int) {
-	fmt.Printf(Esc+"%dB", n)
+	fmt.Fprintf(term, Esc+"%dB", n)
 }
 
 // Move the cursor n cells to right.
 func CursorForward(n int) {
-	fmt.Printf(Esc+"%dC", n)
+	fmt.Fprintf(term, Esc+"%dC", n)
 }
 
 // Move the cursor n cells to left.
 func CursorBack(n int) {
-	fmt.Printf(Esc+"%dD", n)
+	fmt.Fprintf(term, Esc+"%dD", n)
 }
 
 // Move cursor to beginning of the line n lines down.
 func CursorNextLine(n int) {
-	fmt.Printf(Esc+"%dE", n)
+	fmt.Fprintf(term, Esc+"%dE", n)
 }
 
 // Move cursor to beginning of the line n lines up.
 func CursorPreviousLine(n int) {
-	fmt.Printf(Esc+"%dF", n)
+	fmt.Fprintf(term, Esc+"%dF", n)
 }
 
 // Move cursor horizontally to x.
 func CursorHorizontalAbsolute(x int) {
-	fmt.Printf(Esc+"%dG", x)
+	fmt.Fprintf(term, Esc+"%dG", x)
 }
 
 // Show the cursor.
 func CursorShow() {
-	fmt.Print(Esc + "?25h")
+	fmt.Fprint(term, Esc+"?25h")
 }
 
 // Hide the cursor.
 func CursorHide() {
-	fmt.Print(Esc + "?25l")
+	fmt.Fprint(term, Esc+"?25l")
 }
 
 // Save the screen.
 func SaveScreen() {
-	fmt.Print(Esc + "?47h")
+	fmt.Fprint(term, Esc+"?47h")
 }
 
 // Restore the saved screen.
 func RestoreScreen() {
-	fmt.Print(Esc + "?47l")
+	fmt.Fprint(term, Esc+"?47l")
 }
 
-func DropFileData(path string) (string, int, int, int) {
+func DropFileData(path string) (string, int, int, int, int) {
 	// path needs to include trailing slash!
+	var dropUserNum string
 	var dropAlias string
 	var dropTimeLeft string
 	var dropEmulation string
@@ -277,6 +282,9 @@ func DropFileData(path string) (string, int, int, int) {
 
 	count := 0
 	for _, line := range text {
+		if count == 4 {
+			dropUserNum = line
+		}
 		if count == 6 {
 			dropAlias = line
 		}
@@ -312,134 +320,81 @@ func DropFileData(path string) (string, int, int, int) {
 		log.Fatal(err)
 	}
 
-	return dropAlias, timeInt, emuInt, nodeInt
+	userInt, _ := strconv.Atoi(dropUserNum) // 0 when the BBS leaves it out
+
+	return dropAlias, userInt, timeInt, emuInt, nodeInt
 }
 
-/*
-Get the terminal size
-- Send a cursor position that we know is way too large
-- Terminal sends back the largest row + col size
-- Read in the result
-*/
+// Get the terminal size as height, width. See detectTermSize for where the
+// size comes from; it never blocks longer than the probe timeout.
 func GetTermSize() (int, int) {
-	// Set the terminal to raw mode so we aren't waiting for CLRF rom user (to be undone with `-raw`)
-	rawMode := exec.Command("/bin/stty", "raw")
-	rawMode.Stdin = os.Stdin
-	_ = rawMode.Run()
-
-	reader := bufio.NewReader(os.Stdin)
-	fmt.Fprintf(os.Stdout, "\033[999;999f") // larger than any known term size
-	fmt.Fprintf(os.Stdout, "\033[6n")       // ansi escape code for reporting cursor location
-	text, _ := reader.ReadString('R')
-
-	// Set the terminal back from raw mode to 'cooked'
-	rawModeOff := exec.Command("/bin/stty", "-raw")
-	rawModeOff.Stdin = os.Stdin
-	_ = rawModeOff.Run()
-	rawModeOff.Wait()
-
-	// check for the desired output
-	if strings.Contains(string(text), ";") {
-		re := regexp.MustCompile(`\d+;\d+`)
-		line := re.FindString(string(text))
-
-		s := strings.Split(line, ";")
-		sh, sw := s[0], s[1]
-
-		ih, err := strconv.Atoi(sh)
-		if err != nil {
-			// handle error
-			fmt.Println(err)
-			os.Exit(2)
-		}
-
-		iw, err := strconv.Atoi(sw)
-		if err != nil {
-			// handle error
-			fmt.Println(err)
-			os.Exit(2)
-		}
-		h := ih
-		w := iw
-
-		ClearScreen()
-
-		return h, w
-
-	} else {
-		// couldn't detect, so let's just set 80 x 25 to be safe
-		h := 80
-		w := 25
-
-		return h, w
-	}
-
+	w, h, _ := detectTermSize(term, int(os.Stdin.Fd()), cfg, true)
+	return h, w
 }
 
+// ReadAnsiFile reads an art file without its SAUCE metadata, converted from
+// CP437 to UTF-8 so it goes through the terminal writer like any other text.
 func ReadAnsiFile(filePath string) (string, error) {
-	content, err := os.ReadFile(filePath)
+	art, err := ReadArt(filePath)
 	if err != nil {
 		return "", err
 	}
-	return string(content), nil
+	return art.Text, nil
 }
 
+// displayAnsiFile clears the screen and draws an art file, switching to the
+// font and color mode its SAUCE record asks for.
 func displayAnsiFile(filePath string) {
-	content, err := ReadAnsiFile(filePath)
+	art, err := ReadArt(filePath)
 	if err != nil {
 		log.Fatalf("Error reading file %s: %v", filePath, err)
 	}
 	ClearScreen()
-	PrintAnsi(content, 0, localDisplay)
-}
-
-// Print ANSI art with a delay between lines
-func PrintAnsi(artContent string, delay int, localDisplay bool) { // Added localDisplay as an argument
-	noSauce := TrimStringFromSauce(artContent) // strip off the SAUCE metadata
-	lines := strings.Split(noSauce, "\r\n")
-
-	for i, line := range lines {
-		if localDisplay {
-			// Convert line from CP437 to UTF-8
-			utf8Line, err := charmap.CodePage437.NewDecoder().String(line)
-			if err != nil {
-				fmt.Printf("Error converting to UTF-8: %v\n", err)
-				continue
-			}
-			line = utf8Line
-		}
+	applySauceModes(art.Sauce)
+	PrintAnsi(art.Rows(), 0)
+}
 
-		if i < len(lines)-1 && i != 24 { // Check for the 25th line (index 24)
-			fmt.Println(line) // Print with a newline
-		} else {
-			fmt.Print(line) // Print without a newline (for the 25th line and the last line of the art)
-		}
-		time.Sleep(time.Duration(delay) * time.Millisecond)
+// applySauceModes switches the terminal to the font and color mode that art
+// with this SAUCE record was drawn for, as far as the terminal supports them.
+func applySauceModes(s *Sauce) {
+	if font := s.FontSequence(); font != "" && caps.Fonts {
+		fmt.Fprint(term, font)
+	}
+	if s.ICEColors() && caps.ICEColors && caps.Terminal == "SyncTERM" {
+		fmt.Fprint(term, ICEColorsEnable)
 	}
 }
 
-// TrimStringFromSauce trims SAUCE metadata from a string.
-func TrimStringFromSauce(s string) string {
-	return trimMetadata(s, "COMNT", "SAUCE00")
+// resetSauceModes puts the font and color mode back the way the BBS expects
+// them after the door exits.
+func resetSauceModes(s *Sauce) {
+	if s.FontSequence() != "" && caps.Fonts {
+		fmt.Fprint(term, Ibm)
+	}
+	if s.ICEColors() && caps.ICEColors && caps.Terminal == "SyncTERM" {
+		fmt.Fprint(term, ICEColorsDisable)
+	}
 }
 
-// trimMetadata trims metadata based on delimiters.
-func trimMetadata(s string, delimiters ...string) string {
-	for _, delimiter := range delimiters {
-		if idx := strings.Index(s, delimiter); idx != -1 {
-			return trimLastChar(s[:idx])
+// Print rows of ANSI art, as laid out by Art.Rows, with a delay between
+// them. Rows are placed with a cursor move instead of a newline, so
+// full-width rows don't wrap twice and art as tall as the screen doesn't
+// scroll.
+func PrintAnsi(rows []string, delay int) {
+	for i, row := range rows {
+		if u.H > 0 && i >= u.H {
+			break
 		}
+		MoveCursor(1, i+1)
+		fmt.Fprint(term, row)
+		time.Sleep(time.Duration(delay) * time.Millisecond)
 	}
-	return s
 }
 
-// trimLastChar trims the last character from a string.
-func trimLastChar(s string) string {
-	if len(s) > 0 {
-		_, size := utf8.DecodeLastRuneInString(s)
-		return s[:len(s)-size]
-	}
-	return s
+// TrimStringFromSauce trims SAUCE metadata from a string.
+func TrimStringFromSauce(s string) string {
+	data, _ := ParseSauce([]byte(s))
+	return string(data)
 }
 
 // Print ANSI art at an X, Y location
@@ -450,24 +405,19 @@ func PrintAnsiLoc(artfile string, x int, y int) {
 	s := bufio.NewScanner(strings.NewReader(string(noSauce)))
 
 	for s.Scan() {
-		fmt.Fprintf(os.Stdout, Esc+strconv.Itoa(yLoc)+";"+strconv.Itoa(x)+"f"+s.Text())
+		fmt.Fprintf(term, Esc+strconv.Itoa(yLoc)+";"+strconv.Itoa(x)+"f"+s.Text())
 		yLoc++
 	}
 }
 
 // Print text at an X, Y location
 func PrintStringLoc(text string, x int, y int) {
-	fmt.Fprintf(os.Stdout, Esc+strconv.Itoa(y)+";"+strconv.Itoa(x)+"f"+text)
+	fmt.Fprintf(term, Esc+strconv.Itoa(y)+";"+strconv.Itoa(x)+"f"+text)
 }
 
 // CenterText horizontally centers some text
 func CenterText(s string, w int) {
-	padding := (w - len(s)) / 2
-	if padding < 0 {
-		padding = 0
-	}
-	// Pad the left side of the string with spaces to center the text
-	fmt.Fprintf(os.Stdout, Cyan+"%[1]*s\n", -w, fmt.Sprintf("%[1]*s"+Reset, padding+len(s), s))
+	fmt.Fprint(term, Cyan+layout.Center(s, w)+Reset+"\n")
 }
 
 // Horizontally and Vertically center some text.
@@ -476,14 +426,14 @@ func AbsCenterText(s string, l int, c string) {
 	halfLen := l / 2
 	centerX := (modalW - modalW/2) - halfLen
 	MoveCursor(centerX, centerY)
-	fmt.Fprintf(os.Stdout, WhiteHi+c+s+Reset)
+	fmt.Fprintf(term, WhiteHi+c+s+Reset)
 	result := Continue()
 	if result {
-		fmt.Fprintf(os.Stdout, BgCyan+CyanHi+" Yes"+Reset)
+		fmt.Fprintf(term, BgCyan+CyanHi+" Yes"+Reset)
 		time.Sleep(1 * time.Second)
 	}
 	if !result {
-		fmt.Fprintf(os.Stdout, BgCyan+CyanHi+" No"+Reset)
+		fmt.Fprintf(term, BgCyan+CyanHi+" No"+Reset)
 		time.Sleep(1 * time.Second)
 	}
 }
@@ -497,8 +447,8 @@ func AbsCenterArt(artfile string, l int) {
 	s := bufio.NewScanner(strings.NewReader(string(noSauce)))
 
 	for s.Scan() {
-		fmt.Fprintf(os.Stdout, Esc+strconv.Itoa(artY)+";"+strconv.Itoa(artX)+"f")
-		fmt.Println(s.Text())
+		fmt.Fprintf(term, Esc+strconv.Itoa(artY)+";"+strconv.Itoa(artX)+"f")
+		fmt.Fprintln(term, s.Text())
 		artY++
 	}
 }
@@ -521,7 +471,7 @@ func (tm *TimerManager) StartIdleTimer() {
 	}
 
 	tm.idleTimer = time.AfterFunc(tm.idleDuration, func() {
-		fmt.Println("\nYou've been idle for too long... exiting!")
+		fmt.Fprintln(term, "\nYou've been idle for too long... exiting!")
 		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	})
@@ -547,7 +497,7 @@ func (tm *TimerManager) StartMaxTimer() {
 	}
 
 	tm.maxTimer = time.AfterFunc(tm.maxDuration, func() {
-		fmt.Println("\nMax time exceeded... exiting!")
+		fmt.Fprintln(term, "\nMax time exceeded... exiting!")
 		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	})
@@ -580,7 +530,7 @@ func (tm *TimerManager) ResetIdleTimer() {
 	}
 
 	tm.idleTimer = time.AfterFunc(tm.idleDuration, func() {
-		fmt.Println("\nYou've been idle for too long... exiting!")
+		fmt.Fprintln(term, "\nYou've been idle for too long... exiting!")
 		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	})
@@ -596,7 +546,7 @@ func (tm *TimerManager) ResetMaxTimer() {
 	}
 
 	tm.maxTimer = time.AfterFunc(tm.maxDuration, func() {
-		fmt.Println("\nMax time exceeded... exiting!")
+		fmt.Fprintln(term, "\nMax time exceeded... exiting!")
 		time.Sleep(2 * time.Second)
 		os.Exit(0)
 	})
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// The golden tests run the door against a made-up wall, typing keys at it
+// through its Terminal and reading what it sends with vt, and compare the
+// screens they end up on with the ones in testdata/screens. After a change
+// that's meant to alter the screens, look them over and rewrite them with
+//
+//	go test -run Golden -update
+
+var update = flag.Bool("update", false, "rewrite the golden screens in testdata")
+
+// goldenWall is the wall the sessions start with, oldest first. Dates are
+// fixed, and new posts show as "just now", so the screens never change.
+var goldenWall = []Message{
+	{Body: "Wash your hands, you animals", Author: "SysOp", Posted: time.Date(2024, 1, 20, 9, 30, 0, 0, time.UTC)},
+	{Body: "|14Kilroy|07 was here", Author: "Kilroy", Posted: time.Date(2024, 1, 22, 23, 5, 0, 0, time.UTC)},
+	{Body: "Who keeps stealing the good toilet paper? Not cool. Not cool at all.", Author: "j0HNNY a1PHA",
+		Anonymous: true, Posted: time.Date(2024, 1, 25, 17, 45, 0, 0, time.UTC)},
+}
+
+// typist feeds scripted keys to the door. Reads wait for keys, like a
+// caller thinking about what to type next.
+type typist struct {
+	keys chan []byte
+	left []byte
+}
+
+func (k *typist) Read(p []byte) (int, error) {
+	if len(k.left) == 0 {
+		keys, ok := <-k.keys
+		if !ok {
+			return 0, io.EOF
+		}
+		k.left = keys
+	}
+	n := copy(p, k.left)
+	k.left = k.left[n:]
+	return n, nil
+}
+
+// session is the door running for one test caller.
+type session struct {
+	t      *testing.T
+	keys   *typist
+	screen *vt
+}
+
+// startSession points the door's globals at a scratch copy of wall, calls
+// in as aLPHA with the given emulation and waits on the first screen.
+// Everything is put back when the test ends.
+func startSession(t *testing.T, emulation int, wall []Message) *session {
+	t.Helper()
+	saved := struct {
+		cfg     Config
+		store   Store
+		theme   *Theme
+		term    *Terminal
+		scr     *Screen
+		ui      UI
+		u       User
+		caps    Caps
+		start   time.Time
+		pause   time.Duration
+		profile Profile
+	}{cfg, store, theme, term, scr, ui, u, caps, sessionStart, noticeTime, profile}
+	t.Cleanup(func() {
+		cfg, store, theme, term, scr, ui, u, caps = saved.cfg, saved.store, saved.theme,
+			saved.term, saved.scr, saved.ui, saved.u, saved.caps
+		sessionStart, noticeTime, profile = saved.start, saved.pause, saved.profile
+		stallArt, search, pinRun, currentMessageID = nil, nil, nil, 0
+		unread, readPosts = map[int]bool{}, map[int]bool{}
+	})
+
+	store, cfg = newTestStore(t, "flat", wall...)
+	cfg.TimeZone = time.UTC
+	cfg.RelativeDates = true
+	var err error
+	if theme, err = LoadTheme(filepath.Join(cfg.ThemeDir, cfg.Theme)); err != nil {
+		t.Fatal(err)
+	}
+
+	s := &session{t: t}
+	t.Cleanup(func() { close(s.keys.keys) })
+	s.connect(emulation)
+	return s
+}
+
+// connect calls in to the wall as aLPHA, from a fresh terminal, and waits
+// on the first screen. It is how a test comes back for another visit.
+func (s *session) connect(emulation int) {
+	s.t.Helper()
+	if s.keys != nil {
+		close(s.keys.keys)
+	}
+	s.keys, s.screen = &typist{keys: make(chan []byte, 64)}, newVT(80, 25)
+	term = NewTerminal(s.keys, s.screen, CharsetUTF8)
+	u = User{Alias: "aLPHA", UserNum: 1, TimeLeft: time.Hour, Emulation: emulation, NodeNum: 1, W: 80, H: 25}
+	caps = Caps{}
+	scr = NewScreen(screenCols, screenRows)
+	ui = newUI(u.Emulation)
+	if u.Emulation != 0 {
+		scr.Place(u.W, u.H)
+		scr.Out.Cursor(term, false)
+	}
+	sessionStart = time.Now()
+	noticeTime = 0
+	stallArt, search, pinRun, currentMessageID = nil, nil, nil, 0
+	unread, readPosts = map[int]bool{}, map[int]bool{}
+
+	s.run("starting the session", func() bool {
+		loadProfile()
+		findUnread()
+		showEntry()
+		ui.ShowMenu()
+		return true
+	})
+}
+
+// run calls door, failing the test if it panics or sits waiting for keys
+// nobody is going to type. It reports what door returned.
+func (s *session) run(what string, door func() bool) bool {
+	s.t.Helper()
+	type result struct {
+		more  bool
+		panic interface{}
+	}
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		defer func() {
+			r.panic = recover()
+			done <- r
+		}()
+		r.more = door()
+	}()
+	select {
+	case r := <-done:
+		if r.panic != nil {
+			s.t.Fatalf("%s: door panicked: %v", what, r.panic)
+		}
+		return r.more
+	case <-time.After(5 * time.Second):
+		s.t.Fatalf("%s: the door is still waiting for keys", what)
+	}
+	return false
+}
+
+// press types keys and lets the door carry out one command with them,
+// the menu key and whatever the command goes on to ask for, and get back
+// to the menu, as the door's main loop does.
+func (s *session) press(keys ...string) bool {
+	s.t.Helper()
+	s.keys.keys <- []byte(strings.Join(keys, ""))
+	return s.run(fmt.Sprintf("pressing %q", keys), func() bool {
+		if !command(getKey()) {
+			return false
+		}
+		ui.ShowMenu()
+		return true
+	})
+}
+
+// check compares the screen with testdata/screens/name.txt.
+func (s *session) check(name string) {
+	s.t.Helper()
+	got := s.screen.Snapshot()
+	path := filepath.Join("testdata", "screens", name+".txt")
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			s.t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(got), 0o644); err != nil {
+			s.t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path)
+	if err != nil {
+		s.t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		s.t.Errorf("screen %s changed:\n%s", name, screenDiff(string(want), got))
+	}
+}
+
+// screenDiff lists the lines of two snapshots that differ.
+func screenDiff(want, got string) string {
+	wantLines, gotLines := strings.Split(want, "\n"), strings.Split(got, "\n")
+	var b strings.Builder
+	section := ""
+	for i := 0; i < len(wantLines) || i < len(gotLines); i++ {
+		var w, g string
+		if i < len(wantLines) {
+			w = wantLines[i]
+		}
+		if i < len(gotLines) {
+			g = gotLines[i]
+		}
+		if strings.HasPrefix(w, "-- ") {
+			section = w
+		}
+		if w != g {
+			fmt.Fprintf(&b, "%s line %d\n  want %q\n  got  %q\n", section, i+1, w, g)
+		}
+	}
+	return b.String()
+}
+
+func TestGoldenEntry(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	s.check("entry")
+}
+
+func TestGoldenBareWall(t *testing.T) {
+	s := startSession(t, 1, nil)
+	s.check("bare-wall")
+}
+
+func TestGoldenBrowse(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	s.press("P")
+	s.check("previous")
+	s.press("F")
+	s.check("first")
+	s.press("N")
+	s.check("next")
+}
+
+func TestGoldenAddAnonymous(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	s.press("A", "Out of |12order|07 again", "\r", "y", "y")
+	s.check("add-anonymous")
+}
+
+func TestGoldenAddDiscarded(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	s.press("A", "Never mind", "\r", "n")
+	s.check("add-discarded")
+}
+
+func TestGoldenKeepVote(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	s.press("K")
+	s.check("keep-vote")
+}
+
+func TestGoldenPinned(t *testing.T) {
+	wall := append([]Message(nil), goldenWall...)
+	wall[0].Pinned = true
+	s := startSession(t, 1, wall)
+	s.check("pinned")
+	s.press("N")
+	s.check("pinned-then-newest")
+}
+
+func TestGoldenLineMode(t *testing.T) {
+	s := startSession(t, 0, goldenWall)
+	s.press("P")
+	s.check("line-mode")
+}
+
+func TestGoldenQuit(t *testing.T) {
+	s := startSession(t, 0, goldenWall)
+	if s.press("Q") {
+		t.Error("the door kept going after Q")
+	}
+}
+
+func TestEntryLeavesPostsUnread(t *testing.T) {
+	s := startSession(t, 1, goldenWall)
+	before := unreadNotice()
+	if before == "" {
+		t.Fatal("nothing is new on the first visit")
+	}
+	if s.press("Q") {
+		t.Fatal("the door kept going after Q")
+	}
+	s.connect(1)
+	if after := unreadNotice(); after != before {
+		t.Errorf("looking in and leaving changed %q to %q", before, after)
+	}
+
+	// Reading the oldest new post, and only that, leaves the rest new.
+	s.press("U")
+	if s.press("Q") {
+		t.Fatal("the door kept going after Q")
+	}
+	s.connect(1)
+	if got, want := len(unread), len(goldenWall)-1; got != want {
+		t.Errorf("%d posts new after reading one, want %d", got, want)
+	}
+}
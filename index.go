@@ -0,0 +1,452 @@
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+// The index sits next to the message file as <file>.idx and makes finding
+// a message cost the same however big the wall gets. It is a header
+// followed by one fixed-size slot per message id, so the slot for id n is
+// at a known offset:
+//
+//	header: magic "TSIX", version, bytes of the message file indexed,
+//	        lines indexed, highest id, and the message file's size and
+//	        modification time when the index was last saved
+//	slot:   offset and length of the message's latest line, parent id,
+//	        visible replies, flags
+//
+// Every write the door makes goes through the index and saves its header.
+// If the message file's size or modification time differ from the ones in
+// the header, something else changed it: an older copy of the door
+// appended to it, or someone edited it by hand, anywhere in the file. The
+// index is rebuilt from scratch then, and when it's missing or damaged.
+// All of it happens under the store's lock.
+const (
+	indexMagic      = "TSIX"
+	indexVersion    = 3
+	indexEntrySize  = 24
+	indexHeaderSize = 2 * indexEntrySize // room to grow, and slots stay aligned
+)
+
+// Slot flags
+const (
+	slotExists = 1 << iota
+	slotHidden
+	slotPinned
+)
+
+var errBadIndex = errors.New("message index is damaged")
+
+type indexHeader struct {
+	Covered int64  // bytes of the message file indexed
+	Lines   uint32 // lines of the message file indexed
+	MaxID   uint32
+	Size    int64 // of the message file when the header was saved
+	ModTime int64 // of the message file then, in nanoseconds
+}
+
+type indexSlot struct {
+	Offset  int64
+	Length  uint32
+	Parent  uint32
+	Replies uint32
+	Flags   uint32
+}
+
+func (s indexSlot) visible() bool {
+	return s.Flags&slotExists != 0 && s.Flags&slotHidden == 0
+}
+
+// msgIndex is an open index file.
+type msgIndex struct {
+	f    *os.File
+	path string // the message file
+	hdr  indexHeader
+}
+
+// openIndex opens the index for the message file at path and brings it up
+// to date. The caller must hold the store's lock.
+func openIndex(path string) (*msgIndex, error) {
+	f, err := os.OpenFile(path+".idx", os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+	idx := &msgIndex{f: f, path: path}
+
+	size, modTime, err := fileStamp(path)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err = idx.readHeader(); err != nil || idx.hdr.Size != size || idx.hdr.ModTime != modTime {
+		err = idx.rebuild(path)
+	}
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return idx, nil
+}
+
+// fileStamp returns the size and modification time of the file at path, or
+// zeros when there isn't one.
+func fileStamp(path string) (size, modTime int64, err error) {
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return 0, 0, nil
+	}
+	if err != nil {
+		return 0, 0, err
+	}
+	return info.Size(), info.ModTime().UnixNano(), nil
+}
+
+func (idx *msgIndex) Close() error {
+	return idx.f.Close()
+}
+
+func (idx *msgIndex) readHeader() error {
+	buf := make([]byte, indexHeaderSize)
+	if _, err := idx.f.ReadAt(buf, 0); err != nil {
+		return errBadIndex
+	}
+	if string(buf[0:4]) != indexMagic || binary.LittleEndian.Uint32(buf[4:8]) != indexVersion {
+		return errBadIndex
+	}
+	idx.hdr = indexHeader{
+		Covered: int64(binary.LittleEndian.Uint64(buf[8:16])),
+		Lines:   binary.LittleEndian.Uint32(buf[16:20]),
+		MaxID:   binary.LittleEndian.Uint32(buf[20:24]),
+		Size:    int64(binary.LittleEndian.Uint64(buf[24:32])),
+		ModTime: int64(binary.LittleEndian.Uint64(buf[32:40])),
+	}
+	return nil
+}
+
+// stamp notes the message file's size and modification time as they are
+// now, for the header.
+func (idx *msgIndex) stamp() error {
+	var err error
+	idx.hdr.Size, idx.hdr.ModTime, err = fileStamp(idx.path)
+	return err
+}
+
+func (idx *msgIndex) writeHeader() error {
+	buf := make([]byte, indexHeaderSize)
+	copy(buf[0:4], indexMagic)
+	binary.LittleEndian.PutUint32(buf[4:8], indexVersion)
+	binary.LittleEndian.PutUint64(buf[8:16], uint64(idx.hdr.Covered))
+	binary.LittleEndian.PutUint32(buf[16:20], idx.hdr.Lines)
+	binary.LittleEndian.PutUint32(buf[20:24], idx.hdr.MaxID)
+	binary.LittleEndian.PutUint64(buf[24:32], uint64(idx.hdr.Size))
+	binary.LittleEndian.PutUint64(buf[32:40], uint64(idx.hdr.ModTime))
+	_, err := idx.f.WriteAt(buf, 0)
+	return err
+}
+
+// slot reads the slot for id. Ids past the end of the index have an empty
+// slot.
+func (idx *msgIndex) slot(id int) (indexSlot, error) {
+	if id < 1 || uint32(id) > idx.hdr.MaxID {
+		return indexSlot{}, nil
+	}
+	buf := make([]byte, indexEntrySize)
+	if _, err := idx.f.ReadAt(buf, slotOffset(id)); err == io.EOF {
+		return indexSlot{}, nil // never written
+	} else if err != nil {
+		return indexSlot{}, err
+	}
+	return indexSlot{
+		Offset:  int64(binary.LittleEndian.Uint64(buf[0:8])),
+		Length:  binary.LittleEndian.Uint32(buf[8:12]),
+		Parent:  binary.LittleEndian.Uint32(buf[12:16]),
+		Replies: binary.LittleEndian.Uint32(buf[16:20]),
+		Flags:   binary.LittleEndian.Uint32(buf[20:24]),
+	}, nil
+}
+
+func (idx *msgIndex) setSlot(id int, s indexSlot) error {
+	buf := make([]byte, indexEntrySize)
+	binary.LittleEndian.PutUint64(buf[0:8], uint64(s.Offset))
+	binary.LittleEndian.PutUint32(buf[8:12], s.Length)
+	binary.LittleEndian.PutUint32(buf[12:16], s.Parent)
+	binary.LittleEndian.PutUint32(buf[16:20], s.Replies)
+	binary.LittleEndian.PutUint32(buf[20:24], s.Flags)
+	_, err := idx.f.WriteAt(buf, slotOffset(id))
+	return err
+}
+
+func slotOffset(id int) int64 {
+	return indexHeaderSize + int64(id-1)*indexEntrySize
+}
+
+// nextID is the id the next post gets. Lines written before messages had
+// ids are numbered by line, so it has to be past both.
+func (idx *msgIndex) nextID() int {
+	next := idx.hdr.MaxID
+	if idx.hdr.Lines > next {
+		next = idx.hdr.Lines
+	}
+	return int(next) + 1
+}
+
+// add indexes a line just written to the message file that starts at
+// offset and is length bytes long, newline included, and saves the header.
+func (idx *msgIndex) add(m *Message, offset int64, length int) error {
+	if err := idx.apply(m, offset, length); err != nil {
+		return err
+	}
+	if err := idx.stamp(); err != nil {
+		return err
+	}
+	return idx.writeHeader()
+}
+
+// apply updates the slots for a line without saving the header.
+func (idx *msgIndex) apply(m *Message, offset int64, length int) error {
+	idx.hdr.Lines++
+	idx.hdr.Covered = offset + int64(length)
+	if m.ID == 0 {
+		m.ID = int(idx.hdr.Lines)
+	}
+
+	old, err := idx.slot(m.ID)
+	if err != nil {
+		return err
+	}
+	if uint32(m.ID) > idx.hdr.MaxID {
+		idx.hdr.MaxID = uint32(m.ID)
+	}
+
+	s := indexSlot{
+		Offset:  offset,
+		Length:  uint32(length),
+		Parent:  uint32(m.Parent),
+		Replies: old.Replies,
+		Flags:   slotExists,
+	}
+	if m.Deleted {
+		s.Flags = 0
+	} else if m.Hidden {
+		s.Flags |= slotHidden
+	}
+	if m.Pinned && !m.Deleted {
+		s.Flags |= slotPinned
+	}
+	if err := idx.setSlot(m.ID, s); err != nil {
+		return err
+	}
+
+	// Keep the parent's count of visible replies right
+	if m.Parent == 0 || old.visible() == s.visible() {
+		return nil
+	}
+	parent, err := idx.slot(m.Parent)
+	if err != nil || parent.Flags&slotExists == 0 {
+		return err
+	}
+	if s.visible() {
+		parent.Replies++
+	} else if parent.Replies > 0 {
+		parent.Replies--
+	}
+	return idx.setSlot(m.Parent, parent)
+}
+
+// rebuild throws the index away and indexes the whole message file. The
+// file is stamped before it's read, so anything written meanwhile makes
+// the next use rebuild it again.
+func (idx *msgIndex) rebuild(path string) error {
+	if err := idx.f.Truncate(0); err != nil {
+		return err
+	}
+	idx.hdr = indexHeader{}
+	if err := idx.stamp(); err != nil {
+		return err
+	}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return idx.writeHeader()
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	r := bufio.NewReaderSize(file, 64*1024)
+	var offset int64
+	for {
+		line, err := r.ReadString('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			if isBlank(line) {
+				idx.hdr.Lines++
+				idx.hdr.Covered = offset + int64(len(line))
+			} else {
+				m := parseRecord(trimLineEnd(line))
+				if err := idx.apply(&m, offset, len(line)); err != nil {
+					return err
+				}
+			}
+			offset += int64(len(line))
+		}
+		if err == io.EOF {
+			break // a line still being written changes the stamp
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return idx.writeHeader()
+}
+
+// get reads message id through the index. ok is false when there's no such
+// message.
+func (idx *msgIndex) get(path string, id int) (m Message, ok bool, err error) {
+	s, err := idx.slot(id)
+	if err != nil || s.Flags&slotExists == 0 {
+		return Message{}, false, err
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return Message{}, false, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, s.Length)
+	if _, err := file.ReadAt(buf, s.Offset); err != nil {
+		return Message{}, false, err
+	}
+	m = parseRecord(trimLineEnd(string(buf)))
+	m.ID = id
+	m.Replies = int(s.Replies)
+	return m, true, nil
+}
+
+// seek steps through the ids after from, or before it when dir is
+// negative, and returns the first visible post that match accepts. A nil
+// match accepts any post.
+func (idx *msgIndex) seek(path string, from, dir int, match func(*Message) bool) (*Message, error) {
+	if from > int(idx.hdr.MaxID)+1 {
+		from = int(idx.hdr.MaxID) + 1
+	}
+	for id := from + dir; id >= 1 && id <= int(idx.hdr.MaxID); id += dir {
+		s, err := idx.slot(id)
+		if err != nil {
+			return nil, err
+		}
+		if !s.visible() || s.Parent != 0 {
+			continue
+		}
+		m, ok, err := idx.get(path, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok && (match == nil || match(&m)) {
+			return &m, nil
+		}
+	}
+	return nil, nil
+}
+
+// find returns the ids of the visible posts after id from that match
+// accepts, oldest first. Lines are only read when there's a match to run.
+func (idx *msgIndex) find(path string, from int, match func(*Message) bool) ([]int, error) {
+	var file *os.File
+	if match != nil {
+		var err error
+		if file, err = os.Open(path); err != nil {
+			return nil, err
+		}
+		defer file.Close()
+	}
+
+	var ids []int
+	for id := from + 1; id <= int(idx.hdr.MaxID); id++ {
+		s, err := idx.slot(id)
+		if err != nil {
+			return nil, err
+		}
+		if !s.visible() || s.Parent != 0 {
+			continue
+		}
+		if match != nil {
+			buf := make([]byte, s.Length)
+			if _, err := file.ReadAt(buf, s.Offset); err != nil {
+				return nil, err
+			}
+			m := parseRecord(trimLineEnd(string(buf)))
+			m.ID, m.Replies = id, int(s.Replies)
+			if !match(&m) {
+				continue
+			}
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// pinned returns the visible pinned posts, newest first.
+func (idx *msgIndex) pinned(path string) ([]Message, error) {
+	var msgs []Message
+	for id := int(idx.hdr.MaxID); id >= 1; id-- {
+		s, err := idx.slot(id)
+		if err != nil {
+			return nil, err
+		}
+		if !s.visible() || s.Parent != 0 || s.Flags&slotPinned == 0 {
+			continue
+		}
+		m, ok, err := idx.get(path, id)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+// replies returns the visible replies to message id, oldest first. Only
+// the slots are read until a reply turns up, and replies always come after
+// their post.
+func (idx *msgIndex) replies(path string, id int) ([]Message, error) {
+	var msgs []Message
+	for r := id + 1; r <= int(idx.hdr.MaxID); r++ {
+		s, err := idx.slot(r)
+		if err != nil {
+			return nil, err
+		}
+		if !s.visible() || s.Parent != uint32(id) {
+			continue
+		}
+		m, ok, err := idx.get(path, r)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			msgs = append(msgs, m)
+		}
+	}
+	return msgs, nil
+}
+
+func trimLineEnd(line string) string {
+	for len(line) > 0 && (line[len(line)-1] == '\n' || line[len(line)-1] == '\r') {
+		line = line[:len(line)-1]
+	}
+	return line
+}
+
+func isBlank(line string) bool {
+	for _, c := range line {
+		if c != ' ' && c != '\t' && c != '\r' && c != '\n' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Wall sizes the benchmarks run on. Lookups through the index should cost
+// the same at every size; BenchmarkScanLast, which reads every line the way
+// the door used to, shows what they are compared against.
+var benchSizes = []int{1000, 10000, 100000}
+
+// benchStore makes a wall of n posts, every tenth one a reply and every
+// hundredth one flushed, and indexes it.
+func benchStore(b *testing.B, n int) Store {
+	b.Helper()
+	wall := make([]Message, n)
+	posted := time.Date(2024, 1, 25, 0, 0, 0, 0, time.Local)
+	for i := range wall {
+		id := i + 1
+		wall[i] = Message{
+			ID:     id,
+			Body:   fmt.Sprintf("Message number %d, scrawled on the wall for the benchmark", id),
+			Author: "j0HNNY a1PHA",
+			Posted: posted.Add(time.Duration(id) * time.Minute),
+			Hidden: id%100 == 0,
+		}
+		if id%10 == 0 {
+			wall[i].Parent = id - 1
+		}
+	}
+	s, _ := newTestStore(b, "flat", wall...)
+	return s
+}
+
+func BenchmarkLast(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			s := benchStore(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if m, err := s.Seek(math.MaxInt32, -1, nil); err != nil || m == nil {
+					b.Fatal(m, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNth(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			s := benchStore(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, ok, err := s.Get(n/2 + 1); err != nil || !ok {
+					b.Fatal(ok, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkNext(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			s := benchStore(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if m, err := s.Seek(n/2, 1, nil); err != nil || m == nil {
+					b.Fatal(m, err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkScanLast(b *testing.B) {
+	for _, n := range benchSizes {
+		b.Run(fmt.Sprintf("posts=%d", n), func(b *testing.B) {
+			s := benchStore(b, n)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if msgs, err := s.Messages(); err != nil || len(msgs) != n {
+					b.Fatal(len(msgs), err)
+				}
+			}
+		})
+	}
+}
+
+func TestIndexRebuildsAfterEdit(t *testing.T) {
+	// A sysop edits the file by hand, anywhere in it. A longer line makes
+	// the file grow without anything being appended to it; a flag or a typo
+	// fixed in place leaves it the same size.
+	tests := []struct {
+		name     string
+		old, new string
+		bodies   []string
+		hidden   bool // whether the second post is still hidden
+	}{
+		{"longer line", "first", "first and foremost", []string{"first and foremost", "second", "third"}, true},
+		{"unhidden in place", "flags=hidden", "flags=pinned", []string{"first", "second", "third"}, false},
+		{"typo in place", "third", "THIRD", []string{"first", "second", "THIRD"}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s, c := newTestStore(t, "flat")
+			posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+			for _, body := range []string{"first", "second", "third"} {
+				postAt(t, s, body, posted, 0)
+			}
+			if _, err := s.Hide(2, true); err != nil {
+				t.Fatal(err)
+			}
+
+			data, err := os.ReadFile(c.StorePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			edited := strings.Replace(string(data), tt.old, tt.new, 1)
+			if err := os.WriteFile(c.StorePath, []byte(edited), 0644); err != nil {
+				t.Fatal(err)
+			}
+			later := time.Now().Add(time.Minute) // as it would be after a while in an editor
+			if err := os.Chtimes(c.StorePath, later, later); err != nil {
+				t.Fatal(err)
+			}
+
+			for i, want := range tt.bodies {
+				m, ok, err := s.Get(i + 1)
+				if err != nil || !ok || m.Body != want {
+					t.Errorf("message %d is %q, %v, %v, want %q", i+1, m.Body, ok, err, want)
+				}
+			}
+			visible := []int{1, 2, 3}
+			if tt.hidden {
+				visible = []int{1, 3}
+			}
+			if ids, err := s.Find(0, nil); err != nil || !reflect.DeepEqual(ids, visible) {
+				t.Errorf("visible posts %v, %v, want %v", ids, err, visible)
+			}
+			m := postAt(t, s, "fourth", posted, 0)
+			if m.ID <= 3 {
+				t.Errorf("the next post got id %d", m.ID)
+			}
+			if last, err := s.Seek(math.MaxInt32, -1, nil); err != nil || last == nil || last.Body != "fourth" {
+				t.Errorf("the last post is %+v, %v", last, err)
+			}
+		})
+	}
+}
+
+func TestPinnedAndReplies(t *testing.T) {
+	forEachStore(t, func(t *testing.T, s Store, c Config) {
+		posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+		first := postAt(t, s, "rules", posted, 0)
+		postAt(t, s, "chatter", posted, 0)
+		second := postAt(t, s, "more rules", posted, 0)
+		gone := postAt(t, s, "old rules", posted, 0)
+		for _, m := range []*Message{first, second, gone} {
+			if _, err := s.Change(m.ID, func(m *Message) { m.Pinned = true }); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if _, err := s.Hide(gone.ID, true); err != nil {
+			t.Fatal(err)
+		}
+		reply := postAt(t, s, "says who", posted, first.ID)
+		flushed := postAt(t, s, "flushed", posted, first.ID)
+		postAt(t, s, "elsewhere", posted, second.ID)
+		if _, err := s.Hide(flushed.ID, true); err != nil {
+			t.Fatal(err)
+		}
+
+		pinned, err := s.Pinned()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(pinned) != 2 || pinned[0].ID != second.ID || pinned[1].ID != first.ID {
+			t.Errorf("pinned posts %+v, want %d then %d", pinned, second.ID, first.ID)
+		}
+		replies, err := s.Replies(first.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(replies) != 1 || replies[0].ID != reply.ID {
+			t.Errorf("replies %+v, want only %d", replies, reply.ID)
+		}
+		if m, _, err := s.Get(first.ID); err != nil || m.Replies != 1 {
+			t.Errorf("the post counts %d replies, %v, want 1", m.Replies, err)
+		}
+	})
+}
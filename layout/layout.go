@@ -0,0 +1,189 @@
+// Package layout measures and arranges text for a character-cell screen.
+//
+// Widths are counted in screen columns rather than bytes or runes: most
+// characters take one column, East Asian wide characters and many emoji
+// take two, and combining marks take none. ANSI escape sequences and
+// Renegade-style pipe color codes (|00 to |23) take no room at all and are
+// kept intact, so colored text can be wrapped, cut and centered as it is.
+package layout
+
+import (
+	"strings"
+	"unicode/utf8"
+
+	"github.com/mattn/go-runewidth"
+)
+
+// cond measures runes the way a BBS terminal draws them. Characters of
+// ambiguous width, like the box drawing set, are one column wide whatever
+// the host's locale says, as they are in every ANSI art editor.
+var cond = &runewidth.Condition{EastAsianWidth: false}
+
+// RuneWidth returns how many columns r takes on screen.
+func RuneWidth(r rune) int {
+	return cond.RuneWidth(r)
+}
+
+// codeLen returns the length of the escape sequence or pipe code s starts
+// with, or 0 if it doesn't start with one.
+func codeLen(s string) int {
+	switch {
+	case len(s) >= 2 && s[0] == 0x1b && s[1] == '[':
+		for i := 2; i < len(s); i++ {
+			if s[i] >= 0x40 && s[i] <= 0x7e {
+				return i + 1
+			}
+		}
+		return len(s) // unfinished; it still shows nothing
+	case len(s) >= 3 && s[0] == '|' && isPipeCode(s[1], s[2]):
+		return 3
+	}
+	return 0
+}
+
+func isPipeCode(a, b byte) bool {
+	return (a == '0' || a == '1') && b >= '0' && b <= '9' || a == '2' && b >= '0' && b <= '3'
+}
+
+// next splits the first piece off s: a code, which takes no room, or a
+// rune and its width.
+func next(s string) (piece string, width int, code bool) {
+	if n := codeLen(s); n > 0 {
+		return s[:n], 0, true
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	return s[:size], RuneWidth(r), false
+}
+
+// Width returns how many columns s takes on screen.
+func Width(s string) int {
+	width := 0
+	for len(s) > 0 {
+		piece, w, _ := next(s)
+		width += w
+		s = s[len(piece):]
+	}
+	return width
+}
+
+// Strip removes the escape sequences and pipe codes from s.
+func Strip(s string) string {
+	var b strings.Builder
+	for len(s) > 0 {
+		piece, _, code := next(s)
+		if !code {
+			b.WriteString(piece)
+		}
+		s = s[len(piece):]
+	}
+	return b.String()
+}
+
+// cut splits s after at most width columns, never through a character or
+// a code. Codes and combining marks right at the cut stay with the head.
+func cut(s string, width int) (head, rest string) {
+	used, i := 0, 0
+	for i < len(s) {
+		piece, w, _ := next(s[i:])
+		if used+w > width {
+			break
+		}
+		used += w
+		i += len(piece)
+	}
+	return s[:i], s[i:]
+}
+
+// Truncate cuts s down to width columns, ending it with tail when anything
+// had to go. Text that already fits is returned as it is.
+func Truncate(s string, width int, tail string) string {
+	if Width(s) <= width {
+		return s
+	}
+	if Width(tail) > width {
+		tail = ""
+	}
+	head, _ := cut(s, width-Width(tail))
+	return head + tail
+}
+
+// Wrap breaks s into lines of at most width columns, at spaces where it
+// can and through words too long for a line of their own. Line breaks
+// already in s are kept. A width below 1 leaves the lines as they are.
+func Wrap(s string, width int) []string {
+	paragraphs := strings.Split(s, "\n")
+	if width < 1 {
+		return paragraphs
+	}
+	var lines []string
+	for _, p := range paragraphs {
+		line, lineWidth := "", 0
+		for _, word := range strings.Split(p, " ") {
+			wordWidth := Width(word)
+			if line != "" && lineWidth+1+wordWidth <= width {
+				line += " " + word
+				lineWidth += 1 + wordWidth
+				continue
+			}
+			if lineWidth > 0 {
+				lines = append(lines, line)
+				line, lineWidth = "", 0
+			}
+			for wordWidth > width {
+				head, rest := cut(word, width)
+				if Width(head) == 0 {
+					// A character wider than the whole line still has to go
+					// somewhere.
+					piece, _, _ := next(rest)
+					head, rest = head+piece, rest[len(piece):]
+					if rest == "" {
+						break
+					}
+				}
+				lines = append(lines, line+head)
+				line, word = "", rest
+				wordWidth = Width(word)
+			}
+			line += word
+			lineWidth = wordWidth
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// Center puts s in the middle of width columns, padded with spaces on both
+// sides, an odd one out going on the right. Text too wide is cut.
+func Center(s string, width int) string {
+	return Place(s, width, (width-Width(s))/2)
+}
+
+// PadRight left-aligns s in width columns.
+func PadRight(s string, width int) string {
+	return Place(s, width, 0)
+}
+
+// PadLeft right-aligns s in width columns.
+func PadLeft(s string, width int) string {
+	return Place(s, width, width-Width(s))
+}
+
+// Place puts s indent columns in from the left of width columns and pads
+// it out with spaces. The indent is kept within what room there is, and
+// text too wide is cut.
+func Place(s string, width, indent int) string {
+	if width < 0 {
+		width = 0
+	}
+	if Width(s) > width {
+		s = Truncate(s, width, "")
+	}
+	w := Width(s) // a wide character at the cut can leave a column over
+	if indent > width-w {
+		indent = width - w
+	}
+	if indent < 0 {
+		indent = 0
+	}
+	return strings.Repeat(" ", indent) + s + strings.Repeat(" ", width-w-indent)
+}
@@ -0,0 +1,187 @@
+package layout
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+const (
+	yellow = "\x1b[1;33m"
+	reset  = "\x1b[0m"
+)
+
+func TestWidth(t *testing.T) {
+	tests := []struct {
+		text string
+		want int
+	}{
+		{"", 0},
+		{"hello", 5},
+		{"café", 4},                // é as one rune
+		{"café", 4},               // e and a combining acute
+		{"漢字", 4},                  // wide characters
+		{"ｈｉ", 4},                  // fullwidth Latin
+		{"🚽", 2},                   // emoji
+		{"┌──┐", 4},                // box drawing is narrow everywhere
+		{"░▒▓█", 4},                // so are the shading blocks
+		{yellow + "hi" + reset, 2}, // colors take no room
+		{"\x1b[38;2;255;0;0mred", 3},
+		{"\x1b[5;10H", 0}, // cursor movement too
+		{"|14hi|07", 2},   // pipe codes
+		{"|23|00x", 1},
+		{"|24x", 4},     // not a color
+		{"|1x", 3},      // too short to be one
+		{"a|b", 3},      // a plain bar
+		{"\x1b[1;3", 0}, // an escape cut short
+	}
+	for _, tt := range tests {
+		if got := Width(tt.text); got != tt.want {
+			t.Errorf("Width(%q) = %d, want %d", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestRuneWidthIgnoresLocale(t *testing.T) {
+	t.Setenv("RUNEWIDTH_EASTASIAN", "1")
+	for _, r := range "─│┌▀▄°±·" {
+		if w := RuneWidth(r); w != 1 {
+			t.Errorf("RuneWidth(%q) = %d, want 1", r, w)
+		}
+	}
+}
+
+func TestStrip(t *testing.T) {
+	tests := []struct{ text, want string }{
+		{"plain", "plain"},
+		{yellow + "hi" + reset, "hi"},
+		{"|14one|07 two", "one two"},
+		{"|24 stays", "|24 stays"},
+		{"漢|12字", "漢字"},
+	}
+	for _, tt := range tests {
+		if got := Strip(tt.text); got != tt.want {
+			t.Errorf("Strip(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		tail  string
+		want  string
+	}{
+		{"hello", 10, "...", "hello"},
+		{"hello", 5, "...", "hello"},
+		{"hello world", 8, "...", "hello..."},
+		{"hello world", 8, "", "hello wo"},
+		{"hello", 2, "...", "he"}, // no room for the tail
+		{"hello", 0, "...", ""},
+		{"héllo wörld", 7, "…", "héllo …"},
+		{"漢字漢字", 5, "", "漢字"}, // never half a character
+		{"漢字漢字", 6, "..", "漢字.."},
+		{"漢字漢字", 5, "..", "漢.."},
+		{"cafés", 4, "", "café"}, // the accent stays on its letter
+		{yellow + "hello" + reset, 3, "", yellow + "hel"},
+		{"|14hello|07", 4, "", "|14hell"},
+		{"ab" + yellow + "cd", 2, "", "ab" + yellow},
+	}
+	for _, tt := range tests {
+		got := Truncate(tt.text, tt.width, tt.tail)
+		if got != tt.want {
+			t.Errorf("Truncate(%q, %d, %q) = %q, want %q", tt.text, tt.width, tt.tail, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("Truncate(%q, %d, %q) split a rune: %q", tt.text, tt.width, tt.tail, got)
+		}
+	}
+}
+
+func TestWrap(t *testing.T) {
+	tests := []struct {
+		text  string
+		width int
+		want  []string
+	}{
+		{"", 10, []string{""}},
+		{"short", 10, []string{"short"}},
+		{"the quick brown fox", 10, []string{"the quick", "brown fox"}},
+		{"the quick brown fox", 9, []string{"the quick", "brown fox"}},
+		{"one\ntwo three", 5, []string{"one", "two", "three"}},
+		{"abcdefghij", 4, []string{"abcd", "efgh", "ij"}},
+		{"hi abcdefghij", 4, []string{"hi", "abcd", "efgh", "ij"}},
+		{"漢字漢字漢字", 5, []string{"漢字", "漢字", "漢字"}},
+		{"漢 字", 3, []string{"漢", "字"}},
+		{"漢字", 1, []string{"漢", "字"}}, // wider than the line, one a line
+		{"naïve café au lait", 10, []string{"naïve café", "au lait"}},
+		{"|14yellow |12red", 6, []string{"|14yellow", "|12red"}},
+		{yellow + "keep" + reset + " the colors", 8, []string{yellow + "keep" + reset + " the", "colors"}},
+		{"two  spaces", 20, []string{"two  spaces"}},
+		{"no width", 0, []string{"no width"}},
+	}
+	for _, tt := range tests {
+		got := Wrap(tt.text, tt.width)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("Wrap(%q, %d) = %q, want %q", tt.text, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestWrapFits(t *testing.T) {
+	text := "Ｔｈｅ toilet 🚽 wall is |14covered|07 in scrawls, 落書き and graffiti; " +
+		strings.Repeat("x", 50) + " the end"
+	for width := 2; width <= 40; width++ {
+		var joined []string
+		for _, line := range Wrap(text, width) {
+			if w := Width(line); w > width {
+				t.Errorf("width %d: line %q is %d wide", width, line, w)
+			}
+			if !utf8.ValidString(line) {
+				t.Errorf("width %d: line %q splits a rune", width, line)
+			}
+			joined = append(joined, line)
+		}
+		// Nothing is lost but the spaces the lines were broken at.
+		flat := strings.ReplaceAll(strings.Join(joined, ""), " ", "")
+		if flat != strings.ReplaceAll(text, " ", "") {
+			t.Errorf("width %d: wrapping lost text: %q", width, flat)
+		}
+	}
+}
+
+func TestPlace(t *testing.T) {
+	tests := []struct {
+		name  string
+		got   string
+		want  string
+		width int
+	}{
+		{"center", Center("hi", 6), "  hi  ", 6},
+		{"center odd", Center("hi", 5), " hi  ", 5},
+		{"center wide", Center("漢字", 8), "  漢字  ", 8},
+		{"center colored", Center(yellow+"hi"+reset, 4), " " + yellow + "hi" + reset + " ", 4},
+		{"center piped", Center("|14hi", 4), " |14hi ", 4},
+		{"center too long", Center("hello", 3), "hel", 3},
+		{"center wide cut", Center("漢字", 3), "漢 ", 3},
+		{"pad right", PadRight("ab", 4), "ab  ", 4},
+		{"pad right wide", PadRight("字", 3), "字 ", 3},
+		{"pad left", PadLeft("ab", 4), "  ab", 4},
+		{"pad left accented", PadLeft("é", 3), "  é", 3},
+		{"place", Place("ab", 6, 3), "   ab ", 6},
+		{"place past the end", Place("ab", 6, 9), "    ab", 6},
+		{"place before the start", Place("ab", 6, -2), "ab    ", 6},
+		{"no room", Center("ab", 0), "", 0},
+		{"less than no room", Center("ab", -3), "", 0},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.name, tt.got, tt.want)
+		}
+		if w := Width(tt.got); w != tt.width {
+			t.Errorf("%s: %q is %d wide, want %d", tt.name, tt.got, w, tt.width)
+		}
+	}
+}
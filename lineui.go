@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/eiannone/keyboard"
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// lineWidth is how wide line mode wraps messages.
+const lineWidth = 60
+
+// lineUI is the wall for callers without ANSI or Avatar: plain lines of
+// text, a one-line menu and no cursor addressing, so it works on anything
+// from a teletype to a screen reader.
+type lineUI struct{}
+
+// println writes a line with an explicit CR, since raw sockets don't
+// translate newlines.
+func (lineUI) println(a ...interface{}) {
+	fmt.Fprint(term, a...)
+	fmt.Fprint(term, "\r\n")
+}
+
+func (l lineUI) ShowWall(m *Message) {
+	l.println()
+	l.println("-=- The Toilet Stall -=-")
+	if notice := unreadNotice(); notice != "" {
+		l.println("    " + notice)
+	}
+	l.println()
+	if m == nil {
+		l.println("  The walls are bare.")
+		return
+	}
+	if m.Pinned {
+		l.println("  ** Pinned by the sysop **")
+	}
+	for _, line := range layout.Wrap(strings.TrimSpace(stripMarkup(m.Body)), lineWidth) {
+		l.println("  " + line)
+	}
+	l.println(fmt.Sprintf("    -- %s, %s   keep %d, flush %d", m.Byline(), showTime(m.Posted, time.Now()), m.Keep, m.Flush))
+	if m.Replies > 0 {
+		l.println(fmt.Sprintf("    %d scrawled underneath, [V] to read", m.Replies))
+	}
+}
+
+func (l lineUI) ShowReply(parent, reply *Message, n, total int) {
+	l.println()
+	l.println(fmt.Sprintf("-=- Reply %d of %d to %s, [Q] back -=-", n, total, parent.Byline()))
+	l.println()
+	for _, line := range layout.Wrap(strings.TrimSpace(stripMarkup(reply.Body)), lineWidth) {
+		l.println("  " + line)
+	}
+	l.println(fmt.Sprintf("    -- %s, %s   keep %d, flush %d", reply.Byline(), showTime(reply.Posted, time.Now()), reply.Keep, reply.Flush))
+}
+
+// ShowMenu lists the theme's menu on as many lines as it takes.
+func (l lineUI) ShowMenu() {
+	l.println()
+	line := ""
+	for _, m := range theme.Menu {
+		item := "[" + m.Key + "] " + m.Label
+		if line != "" && layout.Width(line)+2+layout.Width(item) > lineWidth+15 {
+			l.println(line)
+			line = ""
+		}
+		if line != "" {
+			line += "  "
+		}
+		line += item
+	}
+	fmt.Fprint(term, line+": ")
+}
+
+func (l lineUI) Compose(limit int) string {
+	l.println()
+	l.println(fmt.Sprintf("Scrawl your message, up to %d characters. Press ENTER when done.", limit))
+	l.println("Color codes like |12 work for callers with color.")
+	fmt.Fprint(term, "> ")
+
+	var text []rune
+	for visibleLen(string(text)) < limit && roomFor(text) {
+		char, key := getKey()
+		if key == keyboard.KeyEnter {
+			break
+		}
+		switch {
+		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+				fmt.Fprint(term, "\b \b")
+			}
+		case key == keyboard.KeySpace:
+			text = append(text, ' ')
+			fmt.Fprint(term, " ")
+		case char != 0:
+			text = append(text, char)
+			fmt.Fprint(term, string(char))
+		}
+	}
+	l.println()
+	return string(text)
+}
+
+func (l lineUI) Confirm(prompt string) bool {
+	fmt.Fprint(term, strings.TrimSpace(prompt)+" ")
+	yes := waitYesNo()
+	if yes {
+		l.println("Yes")
+	} else {
+		l.println("No")
+	}
+	return yes
+}
+
+func (l lineUI) Ask(prompt string, def bool) bool {
+	fmt.Fprint(term, prompt+" "+yesNoHint(def)+" ")
+	yes := waitYesNoEnter(def)
+	if yes {
+		l.println("Yes")
+	} else {
+		l.println("No")
+	}
+	return yes
+}
+
+// Flush does nothing in line mode; the notice says it all.
+func (lineUI) Flush() {}
+
+func (l lineUI) Notice(text string) {
+	l.println(text)
+}
+
+func (l lineUI) Hint(text string) {
+	l.println("  (" + text + ")")
+}
+
+func (l lineUI) Input(prompt string, limit int) string {
+	l.println()
+	fmt.Fprint(term, prompt)
+	var text []rune
+	for {
+		char, key := getKey()
+		switch {
+		case key == keyboard.KeyEnter:
+			l.println()
+			return string(text)
+		case key == keyboard.KeyEsc:
+			l.println()
+			return ""
+		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+				fmt.Fprint(term, "\b \b")
+			}
+		case key == keyboard.KeySpace && len(text) < limit:
+			text = append(text, ' ')
+			fmt.Fprint(term, " ")
+		case char != 0 && len(text) < limit:
+			text = append(text, char)
+			fmt.Fprint(term, string(char))
+		}
+	}
+}
+
+func (l lineUI) Goodbye() {
+	l.println()
+	l.println("Goodbye!")
+}
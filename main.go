@@ -1,20 +1,21 @@
 package main
 
 import (
-	"bufio"
 	"flag"
 	"fmt"
+	"math"
 	"os"
 	"regexp"
 	"strings"
 	"time"
+	"unicode"
 
 	"github.com/eiannone/keyboard"
-	"github.com/muesli/reflow/wordwrap"
 )
 
 type User struct {
 	Alias     string
+	UserNum   int // record number in the BBS's user file
 	TimeLeft  time.Duration
 	Emulation int
 	NodeNum   int
@@ -24,30 +25,36 @@ type User struct {
 	ModalW    int
 }
 
-const (
-	ArtFileDir = "art"
-	startCol   = 25
-	startRow   = 12
-	maxCols    = 25
-	maxRows    = 5
-)
-
 var (
-	DropPath            string
-	timeOut             time.Duration
-	localDisplay        bool
-	u                   User // Global User object
-	currentMessageIndex int  = -1
+	DropPath         string
+	timeOut          time.Duration
+	localDisplay     bool
+	cfg              Config
+	u                User // Global User object
+	timers           *TimerManager
+	currentMessageID int   // the post on screen
+	pinRun           []int // pinned posts left to show on entry, then 0 for the newest post
+	profile          Profile
 )
 
-func init() {
+// parseFlags reads the command line and the config file. It runs from main
+// rather than init so the package can be tested.
+func parseFlags() {
 	timeOut = 1 * time.Minute
 	pathPtr := flag.String("path", "", "path to door32.sys file (optional if --local is set)")
 	localDisplayPtr := flag.Bool("local", false, "use local UTF-8 display instead of CP437")
+	configPtr := flag.String("config", "toilet.cfg", "path to the door's config file")
 	flag.Parse()
 
 	localDisplay = *localDisplayPtr // Set the global variable
 
+	var err error
+	cfg, err = loadConfig(*configPtr)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+
 	if localDisplay {
 		// Set default values when --local is used
 		u = User{
@@ -60,7 +67,7 @@ func init() {
 			ModalH:    25,
 			ModalW:    80,
 		}
-	} else {
+	} else if flag.NArg() == 0 {
 		// Check for required --path argument if --local is not set
 		if *pathPtr == "" {
 			fmt.Fprintln(os.Stderr, "missing required -path argument")
@@ -70,110 +77,53 @@ func init() {
 	}
 }
 
-func addItem(timerManager *TimerManager) {
-	reloadScreen()
-
-	var messageBuffer strings.Builder
-	PrintStringLoc(YellowHi+"Press ENTER when done."+Reset, 56, 7)
-	fmt.Print("\033[?25h") // Show the cursor
-
-	fmt.Print(BgBlue + White)
-	MoveCursor(startCol, startRow)
-
-	row, col := startRow, startCol
-	inputCompleted := false
-	for !inputCompleted {
-		char, key, err := keyboard.GetKey()
-		if err != nil {
-			panic(err) // Handle error properly in production code
-		}
-
-		timerManager.ResetIdleTimer() // Resets the idle timer on key press
-
-		switch {
-		case key == keyboard.KeyEnter:
-			inputCompleted = true
-		case key == keyboard.KeySpace:
-			// Handle space explicitly
-			if len(messageBuffer.String()) < maxCols*maxRows {
-				messageBuffer.WriteRune(' ')
-				fmt.Print(" ")
-				col++
-				if col > startCol+maxCols-1 {
-					col = startCol
-					row++
-					if row > startRow+maxRows-1 {
-						inputCompleted = true
-					} else {
-						MoveCursor(col, row)
-					}
-				}
-			}
-		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
-			if col > startCol || row > startRow {
-				if len(messageBuffer.String()) > 0 {
-					newMessage := messageBuffer.String()[:len(messageBuffer.String())-1]
-					messageBuffer.Reset()
-					messageBuffer.WriteString(newMessage)
-				}
-				if col > startCol {
-					col--
-				} else if row > startRow {
-					row--
-					col = startCol + maxCols - 1
-				}
-				MoveCursor(col, row)
-				fmt.Print(" ")       // Clear the character on the screen
-				MoveCursor(col, row) // Move cursor back to position
-			}
-		default:
-			if len(messageBuffer.String()) < maxCols*maxRows {
-				messageBuffer.WriteRune(char)
-				fmt.Printf("%c", char) // Print the character
-
-				col++
-				if col > startCol+maxCols-1 {
-					col = startCol
-					row++
-					if row > startRow+maxRows-1 {
-						inputCompleted = true // Stop if maximum rows reached
-					} else {
-						MoveCursor(col, row)
-					}
-				}
-			}
-		}
+func addItem() {
+	if composePost(0) {
+		search = nil // so the new post is there to see
+		loadLastMessage()
+	} else {
+		showCurrent()
 	}
+}
 
-	message := messageBuffer.String()
-
-	fmt.Print("\033[?25l") // Hide the cursor
-	fmt.Print(Reset)       // Reset colors
+// composePost lets the caller write a post, a reply when parent is set, and
+// saves it if they want. It reports whether anything was posted.
+func composePost(parent int) bool {
+	box := theme.Region("message")
+	message := ui.Compose(box.W * box.H)
+	if err := validateMarkup(message); err != nil {
+		ui.Notice(markupNotice(err))
+		return false
+	}
 
 	// Ask to save the message
-	saveMessage := askYesNo("Save this message? (Y/N)")
-	if saveMessage {
-		postAnon := askYesNo("Post anonymously? (Y/N) ")
-		saveToFile(message, u.Alias, postAnon)
-	} else {
-		// Discard the message
-		messageBuffer.Reset() // Clear the message buffer
-
-		PrintStringLoc(RedHi+"Message discarded!       "+Reset, 56, 7)
-		time.Sleep(1 * time.Second)
+	saveMessage := ui.Confirm("Save this message? (Y/N)")
+	if !saveMessage {
+		ui.Notice("Message discarded!")
+		return false
+	}
+	postAnon := ui.Ask("Post anonymously?", profile.Anonymous)
+	saveToFile(message, u.Alias, postAnon, parent)
+	countPost()
+	return true
+}
 
-		reloadScreen()
+// getKey waits for the caller to press a key and restarts the idle timer.
+func getKey() (rune, keyboard.Key) {
+	char, key, err := term.GetKey()
+	if err != nil {
+		panic(err) // Handle error properly in production code
 	}
+	if timers != nil {
+		timers.ResetIdleTimer() // Resets the idle timer on key press
+	}
+	return char, key
 }
 
-func askYesNo(prompt string) bool {
+// waitYesNo waits for the caller to press Y or N.
+func waitYesNo() bool {
 	for {
-		PrintStringLoc(YellowHi+prompt+Reset, 56, 7)
-		char, _, err := keyboard.GetKey()
-		if err != nil {
-			panic(err)
-		}
-
+		char, _ := getKey()
 		if char == 'y' || char == 'Y' {
 			return true
 		} else if char == 'n' || char == 'N' {
@@ -182,52 +132,49 @@ func askYesNo(prompt string) bool {
 	}
 }
 
-func reloadScreen() {
-	// Clear the screen and redraw the default state
-	ClearScreen()
-	displayAnsiFile("art/toiletui.ans")
-}
-
-func saveToFile(message, author string, isAnonymous bool) {
-	// Open the file in append mode, create it if it doesn't exist
-	file, err := os.OpenFile("messages.txt", os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
-	if err != nil {
-		panic(err)
+// waitYesNoEnter waits for the caller to press Y or N, or Enter for def.
+func waitYesNoEnter(def bool) bool {
+	for {
+		char, key := getKey()
+		if char == 'y' || char == 'Y' {
+			return true
+		} else if char == 'n' || char == 'N' {
+			return false
+		} else if key == keyboard.KeyEnter {
+			return def
+		}
 	}
-	defer file.Close()
-
-	processedMessage := processMessage(message)
-
-	// Create a writer
-	writer := bufio.NewWriter(file)
-
-	// Get current time
-	currentTime := time.Now().Format("01/02/06 03:04PM")
+}
 
-	// Format the anonymous flag
-	anonymousText := "No"
-	if isAnonymous {
-		anonymousText = "Yes"
+// yesNoHint is how a question shows which answer Enter gives.
+func yesNoHint(def bool) string {
+	if def {
+		return "(Y/n)"
 	}
+	return "(y/N)"
+}
 
-	// Create the line to be written
-	line := fmt.Sprintf("%s, %s, %s, %s\n", processedMessage, author, anonymousText, currentTime)
+// reloadScreen redraws the default state into the screen buffer: the stall
+// art and the status bar. Only what changed is sent on the next refresh.
+func reloadScreen() {
+	drawArt(loadStallArt())
+	drawStatus()
+}
 
-	// Write the line to the file
-	_, err = writer.WriteString(line)
-	if err != nil {
-		panic(err) // handle error properly in production code
+// saveToFile posts a message to the wall, or under message parent when it
+// isn't zero.
+func saveToFile(message, author string, isAnonymous bool, parent int) {
+	m := &Message{
+		Body:      processMessage(message),
+		Author:    author,
+		Anonymous: isAnonymous,
+		Posted:    time.Now(),
+		Parent:    parent,
 	}
-
-	// Flush to make sure the data is written to the file
-	err = writer.Flush()
-	if err != nil {
+	if err := store.Post(m); err != nil {
 		panic(err)
 	}
-
-	reloadScreen()
-	displayMenu()
-	loadMessage()
+	refreshBulletin(store) // a stale bulletin isn't worth ending the session over
 }
 
 // stripAnsiEscapeCodes removes ANSI escape codes from a string
@@ -249,186 +196,352 @@ func escapeCommas(str string) string {
 func processMessage(message string) string {
 	message = stripAnsiEscapeCodes(message)
 	message = removeNullChars(message)
-	return escapeCommas(message)
-}
-
-func readLastMessageFromFile(filename string) (string, error) {
-	file, err := os.Open(filename)
-	if err != nil {
-		return "", err
-	}
-	defer file.Close()
-
-	var lastLine string
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		lastLine = scanner.Text()
-	}
-	if err := scanner.Err(); err != nil {
-		return "", err
+	if cfg.StripColors {
+		message = stripMarkup(message)
 	}
-
-	return extractMessage(lastLine), nil
+	return message
 }
 
-func extractMessage(line string) string {
-	var messageBuilder strings.Builder
-	escapeNext := false
-
-	for _, char := range line {
-		if escapeNext {
-			messageBuilder.WriteRune(char)
-			escapeNext = false
-			continue
-		}
-
-		if char == '\\' {
-			escapeNext = true
-			continue
+// stripControls removes what processMessage leaves of escape sequences and
+// every other control character, which would split a line of the message
+// file or act on callers' terminals. Line breaks and tabs become spaces.
+func stripControls(str string) string {
+	return strings.Map(func(r rune) rune {
+		switch {
+		case r == '\n' || r == '\r' || r == '\t':
+			return ' '
+		case unicode.IsControl(r):
+			return -1
 		}
+		return r
+	}, str)
+}
 
-		if char == ',' {
-			break
+// maxNameBytes caps the author and the other names a message from outside
+// carries, as maxPostBytes does its body.
+const maxNameBytes = 128
+
+// cleanMessage makes a message that came from outside the door, in a
+// packet or an import file, as safe as one typed here, and reports why it
+// can't go on the wall if it still isn't.
+func cleanMessage(m *Message) error {
+	m.Body = stripControls(processMessage(m.Body))
+	m.Author = strings.TrimSpace(stripControls(stripAnsiEscapeCodes(m.Author)))
+	m.Origin = strings.TrimSpace(stripControls(stripAnsiEscapeCodes(m.Origin)))
+	m.OriginNode = stripControls(stripAnsiEscapeCodes(m.OriginNode))
+	m.MsgID = stripControls(stripAnsiEscapeCodes(m.MsgID))
+	if m.Author == "" && !m.Anonymous {
+		return fmt.Errorf("no author")
+	}
+	for _, name := range []string{m.Author, m.Origin, m.OriginNode, m.MsgID} {
+		if len(name) > maxNameBytes {
+			return fmt.Errorf("a name %d bytes long", len(name))
 		}
-
-		messageBuilder.WriteRune(char)
 	}
-
-	return messageBuilder.String()
+	return validateMarkup(m.Body)
 }
 
-func formatMessage(message string, width, height int) []string {
-	wrapped := wordwrap.String(message, width)
-	lines := strings.Split(wrapped, "\n")
+// searchFilter is what Seek matches posts against while browsing; see
+// browseFilter.
+func searchFilter() func(*Message) bool {
+	return browseFilter(search)
+}
 
-	// Center each line horizontally
-	for i, line := range lines {
-		lines[i] = centerText(line, width)
+// browseFilter is what N/P/F/L step through with s as the search: the posts
+// it matches, leaving out pinned ones unless the sysop wants them browsed.
+// Without either every post is browsed.
+func browseFilter(s *Search) func(*Message) bool {
+	switch {
+	case !cfg.BrowsePinned:
+		return func(m *Message) bool { return !m.Pinned && (s == nil || s.Matches(m)) }
+	case s != nil:
+		return s.Matches
 	}
+	return nil
+}
 
-	// Add padding for vertical centering
-	if len(lines) < height {
-		topPadding := (height - len(lines)) / 2
-		bottomPadding := height - len(lines) - topPadding
-		for i := 0; i < topPadding; i++ {
-			lines = append([]string{" "}, lines...)
-		}
-		for i := 0; i < bottomPadding; i++ {
-			lines = append(lines, " ")
-		}
+// postsAfter returns the ids of the visible posts after id from that match
+// accepts, oldest first. A nil match accepts any post.
+func postsAfter(from int, match func(*Message) bool) []int {
+	ids, err := store.Find(from, match)
+	if err != nil {
+		panic(err)
 	}
-
-	return lines
+	return ids
 }
 
-func centerText(text string, width int) string {
-	if len(text) >= width {
-		return text[:width] // Truncate if text is too long
+// pinnedPosts returns the pinned posts callers can see, newest first.
+func pinnedPosts() []Message {
+	pinned, err := store.Pinned()
+	if err != nil {
+		panic(err)
 	}
-	leftPadding := (width - len(text)) / 2
-	rightPadding := width - len(text) - leftPadding
-	return strings.Repeat(" ", leftPadding) + text + strings.Repeat(" ", rightPadding)
+	return pinned
 }
 
-func loadMessage() {
-	message, err := readLastMessageFromFile("messages.txt")
+// currentMessage returns the post on screen, or nil if there isn't one.
+func currentMessage() *Message {
+	m, ok, err := store.Get(currentMessageID)
 	if err != nil {
 		panic(err)
 	}
+	if !ok {
+		return nil
+	}
+	return &m
+}
 
-	formattedLines := formatMessage(message, maxCols, maxRows)
+// showPost puts a post up, or the bare wall when m is nil.
+func showPost(m *Message) {
+	if m == nil {
+		currentMessageID = 0
+		ui.ShowWall(nil)
+		return
+	}
+	currentMessageID = m.ID
+	if !onEntry {
+		markRead(m.ID)
+	}
+	ui.ShowWall(m)
+	if !onEntry {
+		delete(unread, m.ID) // still counted while it's on screen
+	}
+	if search != nil {
+		at, matches := search.Position(m.ID)
+		ui.Hint(fmt.Sprintf("match %d of %d", at, matches))
+	}
+}
 
-	for i, line := range formattedLines {
-		// Display each line in the specified area
-		PrintStringLoc(BgBlue+YellowHi+line+Reset, startCol, startRow+i)
+// showNear shows the first post being browsed after id from, or before it
+// when dir is negative. When there isn't one the post on screen stays up.
+func showNear(from, dir int) {
+	pinRun = nil
+	m, err := store.Seek(from, dir, searchFilter())
+	if err != nil {
+		panic(err)
+	}
+	if m == nil {
+		showCurrent()
+		return
 	}
+	showPost(m)
+}
 
+// showCurrent puts the post on screen up again with fresh tallies. If it
+// has been flushed in the meantime, the one before it takes its place.
+func showCurrent() {
+	if m := currentMessage(); m != nil && !m.Hidden && (search == nil || search.Matches(m)) {
+		showPost(m)
+		return
+	}
+	m, err := store.Seek(currentMessageID, -1, searchFilter())
+	if err == nil && m == nil {
+		m, err = store.Seek(currentMessageID, 1, searchFilter())
+	}
+	if err != nil {
+		panic(err)
+	}
+	showPost(m)
 }
 
-func displayMenu() {
-	PrintStringLoc(Cyan+"["+CyanHi+"A"+Reset+Cyan+"]"+CyanHi+" Add"+Reset, 2, 10)
-	PrintStringLoc(Cyan+"["+CyanHi+"N"+Reset+Cyan+"]"+CyanHi+" Next"+Reset, 2, 12)
-	PrintStringLoc(Cyan+"["+CyanHi+"P"+Reset+Cyan+"]"+CyanHi+" Previous"+Reset, 2, 13)
-	PrintStringLoc(Cyan+"["+CyanHi+"F"+Reset+Cyan+"]"+CyanHi+" First"+Reset, 2, 15)
-	PrintStringLoc(Cyan+"["+CyanHi+"L"+Reset+Cyan+"]"+CyanHi+" Last"+Reset, 2, 16)
-	PrintStringLoc(Cyan+"["+CyanHi+"Q"+Reset+Cyan+"]"+CyanHi+" Quit"+Reset, 2, 18)
+// showEntry puts up what callers see first: the pinned posts, one at a
+// time with N, and after them the newest post. Nothing it puts up counts
+// as read until the caller moves on to it.
+func showEntry() {
+	onEntry = true
+	defer func() { onEntry = false }()
+	pinned := pinnedPosts()
+	if len(pinned) == 0 {
+		loadLastMessage()
+		return
+	}
+	pinRun = nil
+	for _, m := range pinned[1:] {
+		pinRun = append(pinRun, m.ID)
+	}
+	pinRun = append(pinRun, 0)
+	showPost(&pinned[0])
 }
 
+// loadNextMessage shows the next post. On an announcement that isn't
+// browsed with the others it moves on to the rest of the wall, and while
+// there are posts the caller hasn't read it goes through those.
 func loadNextMessage() {
-	// Logic to load and display the next message
-	// Increment currentMessageIndex and load the message
+	if m := currentMessage(); m != nil && m.Pinned && search == nil {
+		if len(pinRun) > 0 {
+			next := pinRun[0]
+			pinRun = pinRun[1:]
+			if next == 0 {
+				loadLastMessage()
+			} else if p, ok, err := store.Get(next); err == nil && ok && !p.Hidden {
+				showPost(&p)
+			} else {
+				loadNextMessage()
+			}
+			return
+		}
+		if !cfg.BrowsePinned {
+			loadLastMessage()
+			return
+		}
+	}
+	if search == nil {
+		if m := nextUnread(currentMessageID); m != nil {
+			showPost(m)
+			return
+		}
+	}
+	showNear(currentMessageID, 1)
 }
 
 func loadPreviousMessage() {
-	// Logic to load and display the previous message
-	// Decrement currentMessageIndex and load the message
+	if m := currentMessage(); m != nil && m.Pinned && search == nil && !cfg.BrowsePinned {
+		loadLastMessage()
+		return
+	}
+	showNear(currentMessageID, -1)
 }
 
 func loadFirstMessage() {
-	// Logic to load and display the first message
-	// Set currentMessageIndex to 0 and load the message
+	showNear(0, 1)
 }
 
 func loadLastMessage() {
-	// Logic to load and display the last message
-	// Set currentMessageIndex to the last index and load the message
+	showNear(math.MaxInt32, -1)
+}
+
+// voteOnMessage votes to flush or keep the message on screen.
+func voteOnMessage(flush bool) {
+	m := currentMessage()
+	if m == nil {
+		return
+	}
+	castVote(m.ID, flush)
+	showCurrent()
+}
+
+// castVote votes on message id for the caller and says how it went.
+func castVote(id int, flush bool) {
+	m, err := store.Vote(id, u.Alias, flush, cfg.FlushVotes)
+	switch {
+	case err == errAlreadyVoted:
+		ui.Notice("You already voted!")
+	case err != nil:
+		panic(err)
+	case m.Hidden:
+		refreshBulletin(store)
+		if profile.Animation {
+			ui.Flush()
+		}
+		ui.Notice("Flushed! *gurgle*")
+	case flush:
+		ui.Notice("Flush vote counted.")
+	default:
+		ui.Notice("Keep vote counted.")
+	}
 }
 
 func main() {
-	// Get door32.sys as user object
-	u = Initialize(DropPath)
+	parseFlags()
 
-	// Exit if no ANSI capabilities (sorry!)
-	if u.Emulation != 1 {
-		fmt.Println("Sorry, ANSI is required to use this...")
-		time.Sleep(time.Duration(2) * time.Second)
-		os.Exit(0)
+	// Sysop subcommands run from the shell, not as a door
+	if flag.NArg() > 0 {
+		os.Exit(runCommand(flag.Args()))
 	}
 
-	if err := keyboard.Open(); err != nil {
-		fmt.Println(err)
+	var err error
+	store, err = openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
 	}
-	defer func() {
-		_ = keyboard.Close()
-	}()
+	defer store.Close()
 
-	// start idle and max timers
-	timerManager := NewTimerManager(timeOut, u.TimeLeft)
-	timerManager.StartIdleTimer()
-	timerManager.StartMaxTimer()
+	// Fade out old graffiti before anyone sees it. A failed cleanup
+	// shouldn't stop the door; admin cleanup reports what went wrong.
+	if done, err := maintain(store, cfg, time.Now()); err == nil && done.changed() {
+		refreshBulletin(store)
+	}
 
-	CursorHide()
-	ClearScreen()
-	displayAnsiFile("art/toiletui.ans")
-	displayMenu()
-	loadMessage()
+	// Raw mode first, so the terminal size probes get their answers
+	// without waiting for the caller to press Enter
+	fd := int(os.Stdin.Fd())
+	restoreTerm, err := makeRaw(fd)
+	if err != nil {
+		fmt.Fprintln(term, err)
+	}
+	defer restoreTerm()
+	term.Telnet = cfg.Telnet == "yes" || (cfg.Telnet == "auto" && isSocket(fd))
 
-	for {
-		char, key, err := keyboard.GetKey()
-		if err != nil {
-			panic(err)
+	// Get door32.sys as user object
+	u = Initialize(DropPath)
+	loadProfile()
+	findUnread()
+	term.Charset = selectCharset(cfg.Charset, u.Emulation, localDisplay)
+	theme = callerTheme(time.Now())
+	caps = sessionCaps(term, cfg, u.Emulation)
+
+	// ANSI and Avatar callers get the full screen, everyone else line mode
+	ui = newUI(u.Emulation)
+
+	// Re-center the stall whenever the caller's window changes size
+	if u.Emulation != 0 {
+		scr.Place(u.W, u.H)
+		term.OnResize = func(w, h int) {
+			u.W, u.H = w, h
+			scr.Place(w, h)
+			refresh()
 		}
+		watchResize(fd, term)
+		scr.Out.Cursor(term, false)
+	}
 
-		timerManager.ResetIdleTimer() // Resets the idle timer on key press
-
-		if string(char) == ("a") || string(char) == ("A") {
-			addItem(timerManager)
-		} else if string(char) == ("n") || string(char) == ("N") {
-			loadNextMessage()
-		} else if string(char) == ("p") || string(char) == ("P") {
-			loadPreviousMessage()
-		} else if string(char) == ("f") || string(char) == ("F") {
-			loadFirstMessage()
-		} else if string(char) == ("l") || string(char) == ("L") {
-			loadLastMessage()
-		} else if string(char) == "q" || string(char) == "Q" || key == keyboard.KeyEsc {
-			defer timerManager.StopIdleTimer()
-			defer timerManager.StopMaxTimer()
-			MoveCursor(1, u.H-1)
-			CenterText("Goodbye!", 75)
-			time.Sleep(time.Duration(1) * time.Second)
-			CursorShow()
-			return
+	// start idle and max timers
+	timers = NewTimerManager(timeOut, u.TimeLeft)
+	timers.StartIdleTimer()
+	timers.StartMaxTimer()
+
+	showEntry()
+	for {
+		ui.ShowMenu()
+		if !command(getKey()) {
+			break
 		}
 	}
+	timers.StopIdleTimer()
+	timers.StopMaxTimer()
+}
+
+// command does what the key the caller pressed at the menu asks for. It
+// reports false when the caller leaves.
+func command(char rune, key keyboard.Key) bool {
+	if string(char) == ("a") || string(char) == ("A") {
+		addItem()
+	} else if string(char) == ("n") || string(char) == ("N") {
+		loadNextMessage()
+	} else if string(char) == ("p") || string(char) == ("P") {
+		loadPreviousMessage()
+	} else if string(char) == ("f") || string(char) == ("F") {
+		loadFirstMessage()
+	} else if string(char) == ("l") || string(char) == ("L") {
+		loadLastMessage()
+	} else if string(char) == ("k") || string(char) == ("K") {
+		voteOnMessage(false)
+	} else if string(char) == ("x") || string(char) == ("X") {
+		voteOnMessage(true)
+	} else if string(char) == ("r") || string(char) == ("R") {
+		replyToMessage()
+	} else if string(char) == ("v") || string(char) == ("V") {
+		readReplies()
+	} else if string(char) == ("s") || string(char) == ("S") {
+		searchWall()
+	} else if string(char) == ("u") || string(char) == ("U") {
+		loadFirstUnread()
+	} else if string(char) == ("o") || string(char) == ("O") {
+		editOptions()
+	} else if string(char) == "q" || string(char) == "Q" || key == keyboard.KeyEsc {
+		ui.Goodbye()
+		return false
+	}
+	return true
 }
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// Posts can be colored with BBS pipe codes: |00 to |15 pick the foreground
+// and |16 to |23 the background, by PC color number. Codes are stored in
+// the message as typed and turned into colors from the theme's palette when
+// the message is drawn. A pipe that isn't followed by a valid code is just
+// a pipe.
+var pipeCode = regexp.MustCompile(`\|([01][0-9]|2[0-3])`)
+
+// maxPostBytes caps a post as stored, codes and all. Codes don't count
+// toward the length callers are held to, so without it a post could be
+// stuffed with them; it also keeps every line of the message file far
+// inside what the store reads in one go.
+const maxPostBytes = 2048
+
+var (
+	errNothingVisible = errors.New("nothing visible")
+	errInvisibleText  = errors.New("text colored like its background")
+	errPostTooLong    = errors.New("too long")
+)
+
+// markupNotice is what a caller is told when their post is refused.
+func markupNotice(err error) string {
+	switch err {
+	case errNothingVisible:
+		return "Nothing to read there!"
+	case errInvisibleText:
+		return "Text matches background!"
+	case errPostTooLong:
+		return "Too many color codes!"
+	}
+	return "That can't go on the wall."
+}
+
+// defaultPalette is what pipe codes look like unless a theme says otherwise.
+var defaultPalette = [16]string{
+	"black", "blue", "green", "cyan", "red", "magenta", "yellow", "white",
+	"black+", "blue+", "green+", "cyan+", "red+", "magenta+", "yellow+", "white+",
+}
+
+// markupRune is one visible character of a post and the SGR sequence it is
+// drawn with.
+type markupRune struct {
+	Ch  rune
+	SGR string
+}
+
+// markupState is the color a post has reached; -1 means the base color.
+type markupState struct {
+	fg, bg int
+}
+
+// walkMarkup calls fn with each visible character of a post and the colors
+// the pipe codes before it chose.
+func walkMarkup(text string, fn func(r rune, state markupState)) {
+	state := markupState{-1, -1}
+	for len(text) > 0 {
+		if n, ok := pipeAt(text); ok {
+			if n < 16 {
+				state.fg = n
+			} else {
+				state.bg = n - 16
+			}
+			text = text[3:]
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(text)
+		fn(r, state)
+		text = text[size:]
+	}
+}
+
+// pipeAt returns the color number of the pipe code text starts with. ok is
+// false if it doesn't start with one.
+func pipeAt(text string) (n int, ok bool) {
+	if len(text) < 3 || text[0] != '|' || !isDigit(text[1]) || !isDigit(text[2]) {
+		return 0, false
+	}
+	n = int(text[1]-'0')*10 + int(text[2]-'0')
+	return n, n <= 23
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// parseMarkup splits a post into its visible characters, each with the SGR
+// sequence it is drawn with. base is the SGR the post starts in.
+func parseMarkup(text, base string) []markupRune {
+	var runes []markupRune
+	walkMarkup(text, func(r rune, state markupState) {
+		runes = append(runes, markupRune{Ch: r, SGR: state.sgr(base)})
+	})
+	return runes
+}
+
+// sgr returns the sequence that selects the state from scratch, using the
+// theme's palette.
+func (s markupState) sgr(base string) string {
+	if s.fg < 0 && s.bg < 0 {
+		return base
+	}
+	seq := Reset + base
+	if s.fg >= 0 {
+		seq += theme.PaletteColor(s.fg, false)
+	}
+	if s.bg >= 0 {
+		seq += theme.PaletteColor(s.bg, true)
+	}
+	return seq
+}
+
+// renderMarkup turns a post's pipe codes into ANSI colors.
+func renderMarkup(text, base string) string {
+	var b strings.Builder
+	b.WriteString(base)
+	sgr := base
+	for _, r := range parseMarkup(text, base) {
+		if r.SGR != sgr {
+			b.WriteString(r.SGR)
+			sgr = r.SGR
+		}
+		b.WriteRune(r.Ch)
+	}
+	return b.String()
+}
+
+// stripMarkup removes the pipe codes from a post.
+func stripMarkup(text string) string {
+	return pipeCode.ReplaceAllString(text, "")
+}
+
+// visibleLen counts the columns a post takes on screen, so the codes don't
+// eat into the length limit and wide characters count for what they use.
+func visibleLen(text string) int {
+	return layout.Width(text)
+}
+
+// roomFor reports whether a post being typed has room for another
+// character under maxPostBytes.
+func roomFor(text []rune) bool {
+	return len(string(text))+utf8.UTFMax <= maxPostBytes
+}
+
+// validateMarkup rejects posts that wouldn't show anything: no visible
+// characters, or text colored the same as its background. It also rejects
+// ones longer than maxPostBytes.
+func validateMarkup(text string) error {
+	if len(text) > maxPostBytes {
+		return errPostTooLong
+	}
+	if strings.TrimSpace(stripMarkup(text)) == "" {
+		return errNothingVisible
+	}
+	var err error
+	walkMarkup(text, func(r rune, state markupState) {
+		if r != ' ' && state.fg >= 0 && state.fg == state.bg {
+			err = errInvisibleText
+		}
+	})
+	return err
+}
+
+// sgrRun matches the color changes renderMarkup writes.
+var sgrRun = regexp.MustCompile(`(?:\x1b\[[0-9;]*m)+`)
+
+// carryColors starts each wrapped line in the color the previous line ended
+// in, so a color change carries on across the wrap.
+func carryColors(lines []string) []string {
+	carry := ""
+	for i, line := range lines {
+		lines[i] = carry + line
+		if runs := sgrRun.FindAllString(line, -1); len(runs) > 0 {
+			carry = runs[len(runs)-1]
+		}
+	}
+	return lines
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateMarkup(t *testing.T) {
+	tests := []struct {
+		text string
+		want error
+	}{
+		{"|12hello |07there", nil},
+		{"|14|17yellow on blue", nil},
+		{"a pipe | and |99 stay as typed", nil},
+		{"|12|04", errNothingVisible},
+		{"   ", errNothingVisible},
+		{"|01|17 blue on blue", errInvisibleText},
+		{"|01|17 |02fixed", nil},
+		{strings.Repeat("|01", 700) + "hi", errPostTooLong},
+		{strings.Repeat("x", maxPostBytes), nil},
+	}
+	for _, tt := range tests {
+		if err := validateMarkup(tt.text); err != tt.want {
+			name := tt.text
+			if len(name) > 40 {
+				name = name[:40] + "..."
+			}
+			t.Errorf("%q: got %v, want %v", name, err, tt.want)
+		}
+	}
+}
+
+func TestStripMarkup(t *testing.T) {
+	text := "|12red|1|23 |+1 |00|07x|24"
+	if got, want := stripMarkup(text), "red|1 |+1 x|24"; got != want {
+		t.Errorf("stripMarkup: got %q, want %q", got, want)
+	}
+	var walked []rune
+	walkMarkup(text, func(r rune, state markupState) { walked = append(walked, r) })
+	if string(walked) != stripMarkup(text) {
+		t.Errorf("walkMarkup saw %q, stripMarkup left %q", string(walked), stripMarkup(text))
+	}
+}
@@ -0,0 +1,684 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var errAlreadyVoted = errors.New("already voted on this message")
+
+// Message is one post on the wall.
+type Message struct {
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	Author    string    `json:"author"`
+	Anonymous bool      `json:"anonymous"`
+	Posted    time.Time `json:"posted"`
+	Flush     int       `json:"flush"`            // votes to flush it
+	Keep      int       `json:"keep"`             // votes to keep it
+	Hidden    bool      `json:"hidden"`           // flushed, waiting for the sysop to look at it
+	Parent    int       `json:"parent,omitempty"` // the post this replies to, 0 for a post on the wall
+	Replies   int       `json:"-"`                // visible replies; counted when the wall is read, not stored
+	Deleted   bool      `json:"-"`                // a flat file line saying the sysop removed it
+	Pinned    bool      `json:"pinned,omitempty"` // kept by the sysop; never expires
+	Expires   time.Time `json:"expires"`          // when it's hidden, if not by the wall's Expire rule
+
+	// Posts that came in from another board say where from; see net.go
+	Origin     string `json:"origin,omitempty"`      // the board's name
+	OriginNode string `json:"origin_node,omitempty"` // its node address
+	MsgID      string `json:"msgid,omitempty"`       // unique across the network
+}
+
+// Byline is the name a message is shown under, with the board it came from
+// when it came from another one.
+func (m *Message) Byline() string {
+	name := m.Author
+	if m.Anonymous {
+		name = "Anonymous"
+	}
+	if m.Origin != "" {
+		name += "@" + m.Origin
+	}
+	return name
+}
+
+// FlatStore keeps the wall in a text file, one message to a line:
+//
+//	body, author, Yes/No, 2024-01-25T05:03:00Z, id=7, parent=3, flush=2, keep=1, flags=hidden+pinned,
+//	expires=2024-02-25T05:00:00Z, origin=Other BBS, node=21:1/100, msgid=21:1/100 0000001a
+//
+// The first four fields are the door's original format, with commas in the
+// body escaped as "\," and, on lines with an id, backslashes as "\\".
+// Times are UTC; lines from older doors have local times like 01/25/24
+// 12:03AM, read in the board's TimeZone. The key=value fields after them
+// are optional, and lines written before they existed get their line
+// number as their id. The file is only appended to between compactions: a
+// changed message is written again with the same id, the last line for an
+// id wins, and a deleted message is written again flagged deleted. An
+// index file next to it finds any message without reading the rest; see
+// index.go.
+//
+// Votes are listed in a second file next to it, so each alias only gets
+// one vote per message.
+type FlatStore struct {
+	Path string
+}
+
+// Messages returns every message, hidden ones included, oldest first.
+func (s *FlatStore) Messages() ([]Message, error) {
+	return s.read()
+}
+
+// Get returns message id. ok is false if there is no such message.
+func (s *FlatStore) Get(id int) (m Message, ok bool, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		m, ok, err = idx.get(s.Path, id)
+		return err
+	})
+	return m, ok, err
+}
+
+// Seek returns the first visible post after id from, or before it when dir
+// is negative, that match accepts, or nil when there isn't one. Seeking
+// forward from 0 finds the first post and back from math.MaxInt32 the last.
+func (s *FlatStore) Seek(from, dir int, match func(*Message) bool) (m *Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		m, err = idx.seek(s.Path, from, dir, match)
+		return err
+	})
+	return m, err
+}
+
+// Find returns the ids of the visible posts after id from that match
+// accepts, oldest first.
+func (s *FlatStore) Find(from int, match func(*Message) bool) (ids []int, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		ids, err = idx.find(s.Path, from, match)
+		return err
+	})
+	return ids, err
+}
+
+// Pinned returns the visible pinned posts, newest first.
+func (s *FlatStore) Pinned() (msgs []Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		msgs, err = idx.pinned(s.Path)
+		return err
+	})
+	return msgs, err
+}
+
+// Replies returns the visible replies to message id, oldest first.
+func (s *FlatStore) Replies(id int) (msgs []Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		msgs, err = idx.replies(s.Path, id)
+		return err
+	})
+	return msgs, err
+}
+
+// Post adds a message to the wall, giving it the next id.
+func (s *FlatStore) Post(m *Message) error {
+	return s.withIndex(func(idx *msgIndex) error {
+		m.ID = idx.nextID()
+		return s.write(idx, m)
+	})
+}
+
+// Vote records alias's vote to flush or keep message id and returns the
+// message with its new tallies. Once flushVotes flush votes outnumber the
+// keeps the message is hidden; zero turns that off.
+func (s *FlatStore) Vote(id int, alias string, flush bool, flushVotes int) (m Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		var ok bool
+		m, ok, err = idx.get(s.Path, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no message %d", id)
+		}
+
+		voted, err := s.hasVoted(id, alias)
+		if err != nil {
+			return err
+		}
+		if voted {
+			return errAlreadyVoted
+		}
+
+		if flush {
+			m.Flush++
+		} else {
+			m.Keep++
+		}
+		if flushVotes > 0 && m.Flush >= flushVotes && m.Flush > m.Keep {
+			m.Hidden = true
+		}
+
+		if err := appendLine(s.Path+".votes", formatVote(Vote{ID: id, Alias: alias, Flush: flush})); err != nil {
+			return err
+		}
+		return s.write(idx, &m)
+	})
+	return m, err
+}
+
+// Change changes message id with change and writes it back, all under the
+// lock, so a vote cast meanwhile isn't lost.
+func (s *FlatStore) Change(id int, change func(*Message)) (m Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		var ok bool
+		m, ok, err = idx.get(s.Path, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no message %d", id)
+		}
+		change(&m)
+		m.ID = id
+		return s.write(idx, &m)
+	})
+	return m, err
+}
+
+// Hide hides message id from the wall, or puts it back, and returns it.
+func (s *FlatStore) Hide(id int, hidden bool) (m Message, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		var ok bool
+		m, ok, err = idx.get(s.Path, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no message %d", id)
+		}
+		m.Hidden = hidden
+		return s.write(idx, &m)
+	})
+	return m, err
+}
+
+// Delete removes message id and the replies to it, and returns how many
+// messages went. Their lines stay in the file until it is compacted.
+func (s *FlatStore) Delete(id int) (n int, err error) {
+	err = s.withIndex(func(idx *msgIndex) error {
+		m, ok, err := idx.get(s.Path, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no message %d", id)
+		}
+		msgs, err := s.read()
+		if err != nil {
+			return err
+		}
+		doomed := []Message{m}
+		for _, r := range msgs {
+			if r.Parent == id {
+				doomed = append(doomed, r)
+			}
+		}
+		for i := range doomed {
+			doomed[i].Deleted = true
+			if err := s.write(idx, &doomed[i]); err != nil {
+				return err
+			}
+		}
+		n = len(doomed)
+		return nil
+	})
+	return n, err
+}
+
+// Compact rewrites the message file with one line per message, dropping
+// old copies of changed messages and deleted ones, and the votes file
+// without votes on deleted messages. If the newest messages were deleted,
+// the newest of them stays as a deleted line, so their ids aren't given
+// out again. The index is rebuilt the next time it's used.
+func (s *FlatStore) Compact() error {
+	unlock, err := lockFile(s.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, err := openIndex(s.Path)
+	if err != nil {
+		return err
+	}
+	lastID := idx.nextID() - 1
+	idx.Close()
+
+	msgs, err := s.read()
+	if err != nil {
+		return err
+	}
+	votes, err := s.Votes()
+	if err != nil {
+		return err
+	}
+
+	var lines []string
+	exists := map[int]bool{}
+	for i := range msgs {
+		lines = append(lines, formatRecord(&msgs[i]))
+		exists[msgs[i].ID] = true
+	}
+	if n := len(msgs); lastID > 0 && (n == 0 || msgs[n-1].ID < lastID) {
+		lines = append(lines, formatRecord(&Message{ID: lastID, Deleted: true}))
+	}
+	var voteLines []string
+	for _, v := range votes {
+		if exists[v.ID] {
+			voteLines = append(voteLines, formatVote(v))
+		}
+	}
+
+	if err := replaceFile(s.Path, lines); err != nil {
+		return err
+	}
+	if err := replaceFile(s.Path+".votes", voteLines); err != nil {
+		return err
+	}
+	if err := os.Remove(s.Path + ".idx"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// Votes returns every vote in the votes file.
+func (s *FlatStore) Votes() ([]Vote, error) {
+	file, err := os.Open(s.Path + ".votes")
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var votes []Vote
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) != 3 {
+			continue
+		}
+		id, err := strconv.Atoi(fields[0])
+		if err != nil {
+			continue
+		}
+		votes = append(votes, Vote{ID: id, Alias: fields[2], Flush: fields[1] == "flush"})
+	}
+	return votes, scanner.Err()
+}
+
+// Import appends messages and votes with the ids they already have.
+func (s *FlatStore) Import(msgs []Message, votes []Vote) error {
+	return s.withIndex(func(idx *msgIndex) error {
+		for i := range msgs {
+			if err := s.write(idx, &msgs[i]); err != nil {
+				return err
+			}
+		}
+		for _, v := range votes {
+			if err := appendLine(s.Path+".votes", formatVote(v)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// Close does nothing; the flat store only holds files open while it uses
+// them.
+func (s *FlatStore) Close() error {
+	return nil
+}
+
+// withIndex runs fn holding the store's lock, with the index up to date.
+func (s *FlatStore) withIndex(fn func(idx *msgIndex) error) error {
+	unlock, err := lockFile(s.Path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	idx, err := openIndex(s.Path)
+	if err != nil {
+		return err
+	}
+	defer idx.Close()
+	return fn(idx)
+}
+
+// hasVoted reports whether alias already voted on message id.
+func (s *FlatStore) hasVoted(id int, alias string) (bool, error) {
+	file, err := os.Open(s.Path + ".votes")
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.SplitN(scanner.Text(), " ", 3)
+		if len(fields) == 3 && fields[0] == strconv.Itoa(id) && strings.EqualFold(fields[2], alias) {
+			return true, nil
+		}
+	}
+	return false, scanner.Err()
+}
+
+// read loads the whole message file.
+func (s *FlatStore) read() ([]Message, error) {
+	file, err := os.Open(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	byID := map[int]Message{}
+	lineNum := 0
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lineNum++
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		m := parseRecord(scanner.Text())
+		if m.ID == 0 {
+			m.ID = lineNum
+		}
+		if m.Deleted {
+			delete(byID, m.ID)
+		} else {
+			byID[m.ID] = m
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	msgs := make([]Message, 0, len(byID))
+	for _, m := range byID {
+		msgs = append(msgs, m)
+	}
+	sort.Slice(msgs, func(i, j int) bool { return msgs[i].ID < msgs[j].ID })
+	return msgs, nil
+}
+
+// write appends a message to the file and indexes it.
+func (s *FlatStore) write(idx *msgIndex, m *Message) error {
+	file, err := os.OpenFile(s.Path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	offset, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		file.Close()
+		return err
+	}
+	line := formatRecord(m) + "\n"
+	if _, err := file.WriteString(line); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+	return idx.add(m, offset, len(line))
+}
+
+// replaceFile swaps the file at path for one holding lines.
+func replaceFile(path string, lines []string) error {
+	var data strings.Builder
+	for _, line := range lines {
+		data.WriteString(line + "\n")
+	}
+	return writeFileAtomic(path, []byte(data.String()))
+}
+
+// writeFileAtomic writes data to a temporary file next to path and renames
+// it into place, so anyone reading path sees either the old file or the
+// new one, never half of it.
+func writeFileAtomic(path string, data []byte) error {
+	file, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmp := file.Name()
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := file.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Chmod(tmp, 0644); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
+
+func appendLine(path, line string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.WriteString(line + "\n"); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// formatRecord writes a message as a line of the message file.
+func formatRecord(m *Message) string {
+	anonymousText := "No"
+	if m.Anonymous {
+		anonymousText = "Yes"
+	}
+	fields := []string{
+		escapeField(m.Body),
+		escapeField(m.Author),
+		anonymousText,
+		storeTime(m.Posted),
+		"id=" + strconv.Itoa(m.ID),
+	}
+	if m.Parent > 0 {
+		fields = append(fields, "parent="+strconv.Itoa(m.Parent))
+	}
+	if m.Flush > 0 {
+		fields = append(fields, "flush="+strconv.Itoa(m.Flush))
+	}
+	if m.Keep > 0 {
+		fields = append(fields, "keep="+strconv.Itoa(m.Keep))
+	}
+	var flags []string
+	if m.Hidden {
+		flags = append(flags, "hidden")
+	}
+	if m.Deleted {
+		flags = append(flags, "deleted")
+	}
+	if m.Pinned {
+		flags = append(flags, "pinned")
+	}
+	if len(flags) > 0 {
+		fields = append(fields, "flags="+strings.Join(flags, "+"))
+	}
+	if !m.Expires.IsZero() {
+		fields = append(fields, "expires="+storeTime(m.Expires))
+	}
+	if m.Origin != "" {
+		fields = append(fields, "origin="+escapeField(m.Origin))
+	}
+	if m.OriginNode != "" {
+		fields = append(fields, "node="+escapeField(m.OriginNode))
+	}
+	if m.MsgID != "" {
+		fields = append(fields, "msgid="+escapeField(m.MsgID))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// formatVote writes a vote as a line of the votes file.
+func formatVote(v Vote) string {
+	vote := "keep"
+	if v.Flush {
+		vote = "flush"
+	}
+	return fmt.Sprintf("%d %s %s", v.ID, vote, v.Alias)
+}
+
+// parseRecord reads a line of the message file. Fields that are missing or
+// don't parse are left at their zero values.
+func parseRecord(line string) Message {
+	fields := splitFields(line)
+	if !hasID(fields) {
+		// Doors older than the id field only escaped commas, so any other
+		// backslash on their lines is part of the text.
+		fields = splitLegacyFields(line)
+	}
+	var m Message
+	get := func(i int) string {
+		if i < len(fields) {
+			return strings.TrimSpace(fields[i])
+		}
+		return ""
+	}
+
+	m.Body = fields[0]
+	m.Author = get(1)
+	m.Anonymous = strings.EqualFold(get(2), "Yes")
+	m.Posted = parseStoredTime(get(3))
+
+	for i := 4; i < len(fields); i++ {
+		key, value, _ := strings.Cut(strings.TrimSpace(fields[i]), "=")
+		n, _ := strconv.Atoi(value)
+		switch key {
+		case "id":
+			m.ID = n
+		case "parent":
+			m.Parent = n
+		case "flush":
+			m.Flush = n
+		case "keep":
+			m.Keep = n
+		case "expires":
+			m.Expires = parseStoredTime(value)
+		case "origin":
+			m.Origin = value
+		case "node":
+			m.OriginNode = value
+		case "msgid":
+			m.MsgID = value
+		case "flags":
+			for _, flag := range strings.Split(value, "+") {
+				switch flag {
+				case "hidden":
+					m.Hidden = true
+				case "deleted":
+					m.Deleted = true
+				case "pinned":
+					m.Pinned = true
+				}
+			}
+		}
+	}
+	return m
+}
+
+// splitFields splits a record on commas that aren't escaped, removing the
+// escapes.
+func splitFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+	escapeNext := false
+
+	for _, char := range line {
+		if escapeNext {
+			field.WriteRune(char)
+			escapeNext = false
+			continue
+		}
+		switch char {
+		case '\\':
+			escapeNext = true
+		case ',':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteRune(char)
+		}
+	}
+	return append(fields, field.String())
+}
+
+// splitLegacyFields splits a line written before backslashes were escaped,
+// where a backslash only escapes the comma after it.
+func splitLegacyFields(line string) []string {
+	var fields []string
+	var field strings.Builder
+
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line) && line[i+1] == ',':
+			field.WriteByte(',')
+			i++
+		case line[i] == ',':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+// hasID reports whether a record's fields include its id, which every line
+// written since backslashes were escaped has.
+func hasID(fields []string) bool {
+	for _, field := range fields[min(len(fields), 4):] {
+		if strings.HasPrefix(strings.TrimSpace(field), "id=") {
+			return true
+		}
+	}
+	return false
+}
+
+// escapeField escapes the characters that would split a field.
+func escapeField(str string) string {
+	return escapeCommas(strings.ReplaceAll(str, "\\", "\\\\"))
+}
@@ -0,0 +1,350 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Boards swap posts by leaving packets for each other: the net export
+// command writes the wall's new local posts and replies into the outbound
+// directory, and net import reads the packets other boards have left in the
+// inbound directory. Moving packets between boards is up to the sysop's
+// mailer, rsync or shared directory.
+//
+// A packet is a JSON envelope signed with HMAC-SHA256 under a secret every
+// board on the network shares. Each message carries a MsgID made of its
+// board's node address and its id there, so a post that turns up twice, or
+// comes back round to the board that wrote it, is only added once.
+
+const packetVersion = 1
+
+// Packet is one batch of messages from one board.
+type Packet struct {
+	Version   int          `json:"version"`
+	Board     string       `json:"board"`
+	Node      string       `json:"node"`
+	Created   time.Time    `json:"created"`
+	Messages  []NetMessage `json:"messages"`
+	Signature string       `json:"signature"`
+}
+
+// NetMessage is a message as it travels between boards. Anonymous posts
+// travel without their author.
+type NetMessage struct {
+	MsgID      string    `json:"msgid"`
+	ReplyTo    string    `json:"reply_to,omitempty"` // the MsgID of the post it replies to
+	Origin     string    `json:"origin"`
+	OriginNode string    `json:"origin_node"`
+	Author     string    `json:"author,omitempty"`
+	Anonymous  bool      `json:"anonymous,omitempty"`
+	Posted     time.Time `json:"posted"`
+	Body       string    `json:"body"`
+}
+
+var errBadSignature = errors.New("bad signature")
+
+// netCommand handles "toilet-redux net export|import|run".
+func netCommand(args []string) int {
+	if len(args) != 1 || (args[0] != "export" && args[0] != "import" && args[0] != "run") {
+		fmt.Fprintln(os.Stderr, "usage: toilet-redux net export|import|run")
+		return 2
+	}
+	if err := cfg.checkNet(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	s, err := openStore(cfg.Store, cfg.StorePath)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	defer s.Close()
+
+	status := 0
+	if args[0] != "import" {
+		n, err := netExport(s, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "net export: %v\n", err)
+			status = 1
+		} else {
+			fmt.Printf("exported %d messages\n", n)
+		}
+	}
+	if args[0] != "export" {
+		n, err := netImport(s, cfg)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "net import: %v\n", err)
+			status = 1
+		}
+		fmt.Printf("imported %d messages\n", n)
+		if n > 0 {
+			if err := refreshBulletin(s); err != nil {
+				fmt.Fprintf(os.Stderr, "bulletin: %v\n", err)
+				status = 1
+			}
+		}
+	}
+	return status
+}
+
+// checkNet makes sure the settings networking needs are there.
+func (cfg *Config) checkNet() error {
+	var missing []string
+	for _, opt := range []struct{ name, value string }{
+		{"NetBoard", cfg.NetBoard}, {"NetNode", cfg.NetNode}, {"NetSecret", cfg.NetSecret},
+		{"NetInbound", cfg.NetInbound}, {"NetOutbound", cfg.NetOutbound},
+	} {
+		if opt.value == "" {
+			missing = append(missing, opt.name)
+		}
+	}
+	if len(missing) > 0 {
+		return fmt.Errorf("networking needs %s set in the config file", strings.Join(missing, ", "))
+	}
+	return nil
+}
+
+// netMsgID is the network-wide id of a message written on board node.
+func netMsgID(node string, id int) string {
+	return fmt.Sprintf("%s %08x", node, id)
+}
+
+// msgIDOf returns a message's network-wide id: the one it came in with, or
+// one made up from this board's node for local messages.
+func msgIDOf(m *Message, node string) string {
+	if m.MsgID != "" {
+		return m.MsgID
+	}
+	return netMsgID(node, m.ID)
+}
+
+// netExport writes the local messages added since the last export into a
+// packet in the outbound directory and returns how many there were. Hidden
+// messages stay home. The id of the last message exported is kept in a
+// file next to the store.
+func netExport(s Store, c Config) (int, error) {
+	statePath := c.StorePath + ".net"
+	last := 0
+	if data, err := os.ReadFile(statePath); err == nil {
+		last, _ = strconv.Atoi(strings.TrimSpace(string(data)))
+	} else if !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	msgs, err := s.Messages()
+	if err != nil {
+		return 0, err
+	}
+	byID := map[int]*Message{}
+	for i := range msgs {
+		byID[msgs[i].ID] = &msgs[i]
+	}
+
+	p := Packet{Version: packetVersion, Board: c.NetBoard, Node: c.NetNode, Created: time.Now().UTC()}
+	newest := last
+	for i := range msgs {
+		m := &msgs[i]
+		if m.ID <= last {
+			continue
+		}
+		if m.ID > newest {
+			newest = m.ID
+		}
+		if m.Origin != "" || m.Hidden {
+			continue
+		}
+		nm := NetMessage{
+			MsgID:      msgIDOf(m, c.NetNode),
+			Origin:     c.NetBoard,
+			OriginNode: c.NetNode,
+			Anonymous:  m.Anonymous,
+			Posted:     m.Posted.UTC(),
+			Body:       m.Body,
+		}
+		if !m.Anonymous {
+			nm.Author = m.Author
+		}
+		if parent, ok := byID[m.Parent]; ok {
+			nm.ReplyTo = msgIDOf(parent, c.NetNode)
+		}
+		p.Messages = append(p.Messages, nm)
+	}
+
+	if len(p.Messages) > 0 {
+		if err := p.sign(c.NetSecret); err != nil {
+			return 0, err
+		}
+		data, err := json.MarshalIndent(p, "", "  ")
+		if err != nil {
+			return 0, err
+		}
+		if err := os.MkdirAll(c.NetOutbound, 0755); err != nil {
+			return 0, err
+		}
+		name := fmt.Sprintf("%s-%d.json", packetPrefix(c.NetNode), p.Created.UnixNano())
+		if err := writeFileAtomic(filepath.Join(c.NetOutbound, name), data); err != nil {
+			return 0, err
+		}
+	}
+	if newest != last {
+		if err := writeFileAtomic(statePath, []byte(strconv.Itoa(newest)+"\n")); err != nil {
+			return 0, err
+		}
+	}
+	return len(p.Messages), nil
+}
+
+// netImport adds the messages from the packets in the inbound directory
+// and returns how many were new. Packets go in in the order they were made,
+// whichever boards they came from, so replies find their posts, and are
+// removed once they're in; a packet that can't be read or whose signature is wrong
+// is renamed to .bad and left for the sysop. Messages are cleaned like
+// local posts, and ones that show nothing after it are refused.
+func netImport(s Store, c Config) (int, error) {
+	names, err := filepath.Glob(filepath.Join(c.NetInbound, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	sort.Strings(names)
+
+	type inbound struct {
+		name string
+		p    *Packet
+	}
+	var packets []inbound
+	var bad, refused []string
+	for _, name := range names {
+		p, err := readPacket(name, c.NetSecret)
+		if err != nil {
+			bad = append(bad, fmt.Sprintf("%s: %v", filepath.Base(name), err))
+			if err := os.Rename(name, name+".bad"); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		packets = append(packets, inbound{name, p})
+	}
+	sort.SliceStable(packets, func(i, j int) bool { return packets[i].p.Created.Before(packets[j].p.Created) })
+
+	msgs, err := s.Messages()
+	if err != nil {
+		return 0, err
+	}
+	known := map[string]int{} // MsgID to local id
+	for i := range msgs {
+		known[msgIDOf(&msgs[i], c.NetNode)] = msgs[i].ID
+	}
+
+	added := 0
+	for _, in := range packets {
+		name, p := in.name, in.p
+		for _, nm := range p.Messages {
+			m := &Message{
+				Body:       nm.Body,
+				Author:     nm.Author,
+				Anonymous:  nm.Anonymous,
+				Posted:     nm.Posted.UTC(),
+				Parent:     known[nm.ReplyTo], // a reply to a post this board never got goes on the wall
+				Origin:     nm.Origin,
+				OriginNode: nm.OriginNode,
+				MsgID:      nm.MsgID,
+			}
+			if m.Origin == "" {
+				m.Origin = p.Board
+			}
+			// Other boards' posts get the same scrubbing as ones typed here,
+			// so a packet can't draw on callers' screens or split the file.
+			if err := cleanMessage(m); err != nil {
+				refused = append(refused, fmt.Sprintf("%s: %s: %v", filepath.Base(name), m.MsgID, err))
+				continue
+			}
+			if _, dup := known[m.MsgID]; dup || m.MsgID == "" {
+				continue
+			}
+			if err := s.Post(m); err != nil {
+				return added, err
+			}
+			known[m.MsgID] = m.ID
+			added++
+		}
+		if err := os.Remove(name); err != nil {
+			return added, err
+		}
+	}
+	var problems []string
+	if len(bad) > 0 {
+		problems = append(problems, "set aside as .bad: "+strings.Join(bad, "; "))
+	}
+	if len(refused) > 0 {
+		problems = append(problems, "messages refused: "+strings.Join(refused, "; "))
+	}
+	if len(problems) > 0 {
+		return added, errors.New(strings.Join(problems, "\n"))
+	}
+	return added, nil
+}
+
+// readPacket reads a packet and checks its signature.
+func readPacket(path, secret string) (*Packet, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var p Packet
+	if err := json.Unmarshal(data, &p); err != nil {
+		return nil, err
+	}
+	if p.Version != packetVersion {
+		return nil, fmt.Errorf("packet version %d, want %d", p.Version, packetVersion)
+	}
+	want, err := p.signature(secret)
+	if err != nil {
+		return nil, err
+	}
+	got, err := hex.DecodeString(p.Signature)
+	if err != nil || !hmac.Equal(got, want) {
+		return nil, errBadSignature
+	}
+	return &p, nil
+}
+
+// signature is the HMAC of the packet with its signature left out.
+func (p Packet) signature(secret string) ([]byte, error) {
+	p.Signature = ""
+	data, err := json.Marshal(p)
+	if err != nil {
+		return nil, err
+	}
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(data)
+	return mac.Sum(nil), nil
+}
+
+func (p *Packet) sign(secret string) error {
+	sig, err := p.signature(secret)
+	if err != nil {
+		return err
+	}
+	p.Signature = hex.EncodeToString(sig)
+	return nil
+}
+
+// packetPrefix makes a node address safe to use in a file name.
+func packetPrefix(node string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' {
+			return r
+		}
+		return '_'
+	}, node)
+}
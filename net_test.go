@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testBoard is a board with its own store and packet directories. Board A
+// keeps its wall in the flat file and board B in SQLite, so the exchange
+// goes through both stores.
+type testBoard struct {
+	cfg   Config
+	store Store
+}
+
+func newTestBoard(t *testing.T, name, node, backend string) *testBoard {
+	t.Helper()
+	s, c := newTestStore(t, backend)
+	dir := filepath.Dir(c.StorePath)
+	c.NetBoard = name
+	c.NetNode = node
+	c.NetSecret = "flush twice"
+	c.NetInbound = filepath.Join(dir, "in")
+	c.NetOutbound = filepath.Join(dir, "out")
+	if err := os.MkdirAll(c.NetInbound, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return &testBoard{cfg: c, store: s}
+}
+
+func (b *testBoard) post(t *testing.T, author, body string, anonymous bool, parent int) *Message {
+	t.Helper()
+	m := &Message{Body: body, Author: author, Anonymous: anonymous, Parent: parent,
+		Posted: time.Date(2024, 1, 25, 12, 0, 0, 0, time.Local)}
+	if err := b.store.Post(m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func (b *testBoard) find(t *testing.T, body string) *Message {
+	t.Helper()
+	msgs, err := b.store.Messages()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := range msgs {
+		if msgs[i].Body == body {
+			return &msgs[i]
+		}
+	}
+	t.Fatalf("%s has no message %q", b.cfg.NetBoard, body)
+	return nil
+}
+
+// send exports from b and hands the packets to the other board, the way a
+// mailer would. It returns the packets' paths in to's inbound directory.
+func (b *testBoard) send(t *testing.T, to *testBoard, want int) []string {
+	t.Helper()
+	n, err := netExport(b.store, b.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("%s exported %d messages, want %d", b.cfg.NetBoard, n, want)
+	}
+	names, _ := filepath.Glob(filepath.Join(b.cfg.NetOutbound, "*.json"))
+	var delivered []string
+	for _, name := range names {
+		dest := filepath.Join(to.cfg.NetInbound, filepath.Base(name))
+		if err := os.Rename(name, dest); err != nil {
+			t.Fatal(err)
+		}
+		delivered = append(delivered, dest)
+	}
+	return delivered
+}
+
+func (b *testBoard) receive(t *testing.T, want int) {
+	t.Helper()
+	n, err := netImport(b.store, b.cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != want {
+		t.Fatalf("%s imported %d messages, want %d", b.cfg.NetBoard, n, want)
+	}
+}
+
+func TestNetExchange(t *testing.T) {
+	a := newTestBoard(t, "Stall A", "21:1/100", "flat")
+	b := newTestBoard(t, "Stall B", "21:1/200", "sqlite")
+
+	hello := a.post(t, "alpha", "hello from A", false, 0)
+	a.post(t, "alpha", "who wrote this", true, 0)
+	b.post(t, "bravo", "hello from B", false, 0)
+
+	a.send(t, b, 2)
+	b.receive(t, 2)
+
+	got := b.find(t, "hello from A")
+	if got.Byline() != "alpha@Stall A" || got.OriginNode != "21:1/100" || got.MsgID == "" {
+		t.Errorf("imported post is %q from %q with msgid %q", got.Byline(), got.OriginNode, got.MsgID)
+	}
+	if anon := b.find(t, "who wrote this"); anon.Author != "" || anon.Byline() != "Anonymous@Stall A" {
+		t.Errorf("anonymous post came in as %q by %q", anon.Byline(), anon.Author)
+	}
+
+	// B replies to A's post; the reply lands under the original on A
+	b.post(t, "bravo", "nice one", false, got.ID)
+	packets := b.send(t, a, 2)
+	if len(packets) != 1 {
+		t.Fatalf("got %d packets, want 1", len(packets))
+	}
+	saved, err := os.ReadFile(packets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.receive(t, 2)
+	if reply := a.find(t, "nice one"); reply.Parent != hello.ID || reply.Byline() != "bravo@Stall B" {
+		t.Errorf("reply came in under %d as %q, want under %d", reply.Parent, reply.Byline(), hello.ID)
+	}
+
+	// The same packet again adds nothing, and A's own posts don't echo back
+	if err := os.WriteFile(packets[0], saved, 0644); err != nil {
+		t.Fatal(err)
+	}
+	a.receive(t, 0)
+	a.send(t, b, 0)
+}
+
+func TestNetImportsPacketsInOrder(t *testing.T) {
+	// C's node sorts before A's, so by file name C's reply would come in
+	// before the post it replies to.
+	a := newTestBoard(t, "Stall A", "21:1/300", "flat")
+	b := newTestBoard(t, "Stall B", "21:1/200", "sqlite")
+	c := newTestBoard(t, "Stall C", "21:1/100", "flat")
+
+	a.post(t, "alpha", "hello from A", false, 0)
+	packets := a.send(t, c, 1)
+	data, err := os.ReadFile(packets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(b.cfg.NetInbound, filepath.Base(packets[0])), data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	c.receive(t, 1)
+	c.post(t, "charlie", "hello yourself", false, c.find(t, "hello from A").ID)
+	c.send(t, b, 1)
+
+	b.receive(t, 2)
+	hello := b.find(t, "hello from A")
+	if reply := b.find(t, "hello yourself"); reply.Parent != hello.ID {
+		t.Errorf("the reply came in under %d, want under %d", reply.Parent, hello.ID)
+	}
+}
+
+func TestNetRejectsTamperedPackets(t *testing.T) {
+	a := newTestBoard(t, "Stall A", "21:1/100", "flat")
+	b := newTestBoard(t, "Stall B", "21:1/200", "flat")
+
+	a.post(t, "alpha", "hello from A", false, 0)
+	packets := a.send(t, b, 1)
+	data, err := os.ReadFile(packets[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	forged := strings.Replace(string(data), "hello from A", "send me your password", 1)
+	if err := os.WriteFile(packets[0], []byte(forged), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := netImport(b.store, b.cfg)
+	if err == nil || n != 0 {
+		t.Fatalf("imported %d messages from a forged packet, err %v", n, err)
+	}
+	if _, err := os.Stat(packets[0] + ".bad"); err != nil {
+		t.Errorf("forged packet wasn't set aside: %v", err)
+	}
+}
+
+func TestNetCleansIncomingMessages(t *testing.T) {
+	for _, backend := range []string{"flat", "sqlite"} {
+		t.Run(backend, func(t *testing.T) {
+			b := newTestBoard(t, "Stall B", "21:1/200", backend)
+			posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.UTC)
+			p := Packet{Version: packetVersion, Board: "Stall\nC", Node: "21:1/300", Created: posted,
+				Messages: []NetMessage{
+					{MsgID: "21:1/300 00000001", Origin: "Stall\r\nC", OriginNode: "21:1/300",
+						Author: "x\x1b[2Jy", Posted: posted, Body: "first line\nsecond\x1b[2J line, id=99"},
+					{MsgID: "21:1/300 00000002", Origin: "Stall C", OriginNode: "21:1/300",
+						Author: "gamma", Posted: posted, Body: "\x1b[2J\n"},
+				}}
+			if err := p.sign(b.cfg.NetSecret); err != nil {
+				t.Fatal(err)
+			}
+			data, err := json.Marshal(p)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(filepath.Join(b.cfg.NetInbound, "c.json"), data, 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			n, err := netImport(b.store, b.cfg)
+			if n != 1 || err == nil {
+				t.Fatalf("imported %d with error %v, want 1 and the empty post refused", n, err)
+			}
+
+			// Read the wall back from scratch, so a record split by a newline shows.
+			s, err := openStore(b.cfg.Store, b.cfg.StorePath)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer s.Close()
+			msgs, err := s.Messages()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(msgs) != 1 {
+				t.Fatalf("the wall has %d messages, want 1: %+v", len(msgs), msgs)
+			}
+			m := msgs[0]
+			if m.Body != "first line second line, id=99" || m.Author != "xy" || m.Origin != "Stall  C" {
+				t.Errorf("got body %q, author %q, origin %q", m.Body, m.Author, m.Origin)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// loadProfile fetches the caller's profile at the start of the session and
+// notes that they've been on. A store that can't be read isn't worth
+// turning the caller away for; they get the defaults.
+func loadProfile() {
+	p, err := store.UpdateProfile(u.Alias, u.UserNum, func(p *Profile) {
+		p.LastOn = time.Now()
+	})
+	if err != nil {
+		p = newProfile(u.Alias, u.UserNum)
+	}
+	profile = p
+}
+
+// updateProfile changes the caller's profile and keeps the saved copy,
+// which may have been changed by the caller on another node too.
+func updateProfile(change func(*Profile)) {
+	if p, err := store.UpdateProfile(u.Alias, u.UserNum, change); err == nil {
+		profile = p
+	} else {
+		change(&profile) // keep it for this session at least
+	}
+}
+
+// markRead notes that the caller has read post id and moves their last
+// read pointer up over the posts after it they've read. It stops short of
+// the first one they haven't, whether they skipped it or it was written
+// on another node while they were on, so it's still new next visit.
+func markRead(id int) {
+	readPosts[id] = true
+	if id <= profile.LastRead {
+		return
+	}
+	last := profile.LastRead
+	for _, next := range postsAfter(profile.LastRead, nil) {
+		if !readPosts[next] {
+			break
+		}
+		last = next
+	}
+	if last == profile.LastRead {
+		return
+	}
+	updateProfile(func(p *Profile) {
+		if last > p.LastRead {
+			p.LastRead = last
+		}
+	})
+}
+
+// countPost adds one to the caller's post count.
+func countPost() {
+	updateProfile(func(p *Profile) { p.Posts++ })
+}
+
+// callerTheme loads the theme the caller picked, or the board's for the
+// time of day if they haven't picked one or it's gone.
+func callerTheme(now time.Time) *Theme {
+	if profile.Theme != "" {
+		if t, err := loadNamedTheme(profile.Theme); err == nil {
+			return t
+		}
+	}
+	return selectTheme(cfg, now)
+}
+
+// loadNamedTheme loads a theme from ThemeDir by the name a caller gave it.
+// Names that could lead out of ThemeDir are turned down before anything
+// is opened.
+func loadNamedTheme(name string) (*Theme, error) {
+	if name == "" || strings.ContainsAny(name, `/\.`) {
+		return nil, fmt.Errorf("bad theme name %q", name)
+	}
+	return LoadTheme(filepath.Join(cfg.ThemeDir, name))
+}
+
+// editOptions lets the caller change their preferences, one question at a
+// time, with Enter keeping what they have. A theme name of "-" goes back to
+// the board's.
+func editOptions() {
+	anonymous := ui.Ask("Anonymous posts?", profile.Anonymous)
+	animation := ui.Ask("Flush animation?", profile.Animation)
+
+	name := profile.Theme
+	if answer := strings.TrimSpace(ui.Input("Theme: ", 20)); answer == "-" {
+		name = ""
+	} else if answer != "" {
+		if _, err := loadNamedTheme(answer); err != nil {
+			ui.Notice("No theme called " + answer + ".")
+		} else {
+			name = answer
+		}
+	}
+
+	updateProfile(func(p *Profile) {
+		p.Anonymous, p.Animation, p.Theme = anonymous, animation, name
+	})
+	if t := callerTheme(time.Now()); t.Dir != theme.Dir {
+		theme = t
+		stallArt = nil // the new theme's art is loaded on the next redraw
+	}
+	ui.Notice("Options saved.")
+	showCurrent()
+}
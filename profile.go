@@ -0,0 +1,166 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Profile is what the door remembers about a caller between sessions. A
+// caller is their alias together with their record number in the BBS's
+// user file, so a new caller who picks a departed caller's alias starts
+// afresh.
+type Profile struct {
+	Alias     string    `json:"alias"`
+	UserNum   int       `json:"user"`
+	Anonymous bool      `json:"anonymous"`       // posts default to anonymous
+	Animation bool      `json:"animation"`       // show the flush animation
+	Theme     string    `json:"theme,omitempty"` // the caller's pick, "" for the board's
+	LastRead  int       `json:"last_read"`       // the newest post they've seen
+	Posts     int       `json:"posts"`
+	LastOn    time.Time `json:"last_on"`
+}
+
+// newProfile is a caller's profile before they've changed anything.
+func newProfile(alias string, userNum int) Profile {
+	return Profile{Alias: alias, UserNum: userNum, Animation: true}
+}
+
+// sameCaller reports whether p belongs to alias and userNum. Aliases are
+// matched without regard to case, as BBSes do.
+func (p *Profile) sameCaller(alias string, userNum int) bool {
+	return strings.EqualFold(p.Alias, alias) && p.UserNum == userNum
+}
+
+// Profiles in the flat store are kept in a file next to the message file,
+// one caller to a line, in the message file's style:
+//
+//	aLPHA, user=1, anonymous=yes, animation=no, theme=classic, last_read=42, posts=7,
+//	last_on=2024-01-25T05:03:00Z
+//
+// The file is small and rewritten whole, under a lock, for every change.
+
+func (s *FlatStore) profilePath() string {
+	return s.Path + ".users"
+}
+
+// Profile returns a caller's profile, or a new one if they haven't got one.
+func (s *FlatStore) Profile(alias string, userNum int) (Profile, error) {
+	profiles, err := s.Profiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	for _, p := range profiles {
+		if p.sameCaller(alias, userNum) {
+			return p, nil
+		}
+	}
+	return newProfile(alias, userNum), nil
+}
+
+// UpdateProfile changes a caller's profile with change and saves it. Other
+// nodes wait, so a change is never made to a profile that's out of date.
+func (s *FlatStore) UpdateProfile(alias string, userNum int, change func(*Profile)) (Profile, error) {
+	unlock, err := lockFile(s.profilePath())
+	if err != nil {
+		return Profile{}, err
+	}
+	defer unlock()
+
+	profiles, err := s.Profiles()
+	if err != nil {
+		return Profile{}, err
+	}
+	i := 0
+	for i < len(profiles) && !profiles[i].sameCaller(alias, userNum) {
+		i++
+	}
+	if i == len(profiles) {
+		profiles = append(profiles, newProfile(alias, userNum))
+	}
+	change(&profiles[i])
+
+	lines := make([]string, len(profiles))
+	for j := range profiles {
+		lines[j] = formatProfile(&profiles[j])
+	}
+	if err := replaceFile(s.profilePath(), lines); err != nil {
+		return Profile{}, err
+	}
+	return profiles[i], nil
+}
+
+// Profiles returns every caller's profile.
+func (s *FlatStore) Profiles() ([]Profile, error) {
+	file, err := os.Open(s.profilePath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var profiles []Profile
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+		profiles = append(profiles, parseProfile(scanner.Text()))
+	}
+	return profiles, scanner.Err()
+}
+
+func formatProfile(p *Profile) string {
+	yesNo := func(b bool) string {
+		if b {
+			return "yes"
+		}
+		return "no"
+	}
+	fields := []string{
+		escapeField(p.Alias),
+		"user=" + strconv.Itoa(p.UserNum),
+		"anonymous=" + yesNo(p.Anonymous),
+		"animation=" + yesNo(p.Animation),
+	}
+	if p.Theme != "" {
+		fields = append(fields, "theme="+escapeField(p.Theme))
+	}
+	fields = append(fields, "last_read="+strconv.Itoa(p.LastRead), "posts="+strconv.Itoa(p.Posts))
+	if !p.LastOn.IsZero() {
+		fields = append(fields, "last_on="+storeTime(p.LastOn))
+	}
+	return strings.Join(fields, ", ")
+}
+
+// parseProfile reads a line of the profiles file. Fields that are missing
+// keep their new profile values.
+func parseProfile(line string) Profile {
+	fields := splitFields(line)
+	p := newProfile(strings.TrimSpace(fields[0]), 0)
+	for _, field := range fields[1:] {
+		key, value, _ := strings.Cut(strings.TrimSpace(field), "=")
+		n, _ := strconv.Atoi(value)
+		switch key {
+		case "user":
+			p.UserNum = n
+		case "anonymous":
+			p.Anonymous = value == "yes"
+		case "animation":
+			p.Animation = value == "yes"
+		case "theme":
+			p.Theme = value
+		case "last_read":
+			p.LastRead = n
+		case "posts":
+			p.Posts = n
+		case "last_on":
+			p.LastOn = parseStoredTime(value)
+		}
+	}
+	return p
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Backend turns screen buffer changes into the codes a caller's terminal
+// understands. Coordinates are 1-based terminal positions.
+type Backend interface {
+	Clear(w io.Writer)
+	MoveTo(w io.Writer, x, y int)
+	SetAttr(w io.Writer, a Attr, fg, bg RGB)
+	Repeat(w io.Writer, ch rune, n int)
+	Cursor(w io.Writer, visible bool)
+}
+
+// rawWriter is a writer with a second way in for control codes, which go
+// out as they are rather than through the charset conversion text gets.
+type rawWriter interface {
+	io.Writer
+	WriteRaw(p []byte) (int, error)
+}
+
+// writeRaw sends control code bytes to w, past its charset conversion if
+// it has one.
+func writeRaw(w io.Writer, p []byte) {
+	if r, ok := w.(rawWriter); ok {
+		r.WriteRaw(p)
+		return
+	}
+	w.Write(p)
+}
+
+// ansiBackend speaks ANSI-BBS, plus whatever extras the caller's terminal
+// was found to support.
+type ansiBackend struct {
+	TrueColor bool // send 24-bit colors as they are
+	Aixterm   bool // bright backgrounds as SGR 100-107 rather than blink
+}
+
+func (ansiBackend) Clear(w io.Writer) {
+	io.WriteString(w, Reset+EraseScreen)
+}
+
+func (ansiBackend) MoveTo(w io.Writer, x, y int) {
+	fmt.Fprintf(w, Esc+"%d;%dH", y, x)
+}
+
+func (b ansiBackend) SetAttr(w io.Writer, a Attr, fg, bg RGB) {
+	if !b.TrueColor && !(b.Aixterm && a&attrBlink != 0) {
+		io.WriteString(w, a.sgr())
+		return
+	}
+
+	seq := Esc + "0"
+	if a.fg() >= 8 {
+		seq += ";1"
+	}
+	if b.TrueColor && fg != 0 {
+		r, g, bl := fg.components()
+		seq += fmt.Sprintf(";38;2;%d;%d;%d", r, g, bl)
+	} else {
+		seq += ";" + strconv.Itoa(30+ansiColor[a.fg()&7])
+	}
+	switch {
+	case b.TrueColor && bg != 0:
+		r, g, bl := bg.components()
+		seq += fmt.Sprintf(";48;2;%d;%d;%d", r, g, bl)
+	case a&attrBlink != 0 && b.Aixterm:
+		seq += ";" + strconv.Itoa(100+ansiColor[a.bg()])
+	case a&attrBlink != 0:
+		seq += ";5;" + strconv.Itoa(40+ansiColor[a.bg()])
+	default:
+		seq += ";" + strconv.Itoa(40+ansiColor[a.bg()])
+	}
+	io.WriteString(w, seq+"m")
+}
+
+func (ansiBackend) Repeat(w io.Writer, ch rune, n int) {
+	io.WriteString(w, strings.Repeat(string(ch), n))
+}
+
+func (ansiBackend) Cursor(w io.Writer, visible bool) {
+	if visible {
+		io.WriteString(w, Esc+"?25h")
+	} else {
+		io.WriteString(w, Esc+"?25l")
+	}
+}
+
+// Avatar/0 control codes
+const (
+	avtClear  = 0x0c // ^L clear screen
+	avtRepeat = 0x19 // ^Y <char> <count>
+	avtCmd    = 0x16 // ^V, followed by one of:
+	avtAttr   = 0x01 // ^A <attr>
+	avtBlink  = 0x02 // ^B
+	avtGoto   = 0x08 // ^H <row> <col>
+
+	avtMinRun = 4 // shorter runs are cheaper sent as they are
+	avtMaxRun = 0x7f
+)
+
+// avatarBackend speaks Avatar/0, whose codes are a few bytes where ANSI
+// needs up to a dozen, and which sends runs of a character as three bytes.
+// That makes a real difference to callers on slow links. The bytes after
+// a code are numbers rather than characters, so they go out raw; through
+// the charset a column past 127 would turn into a '?'.
+type avatarBackend struct{}
+
+func (avatarBackend) Clear(w io.Writer) {
+	writeRaw(w, []byte{avtClear})
+}
+
+func (avatarBackend) MoveTo(w io.Writer, x, y int) {
+	writeRaw(w, []byte{avtCmd, avtGoto, byte(y), byte(x)})
+}
+
+// SetAttr sends the PC attribute; Avatar has no 24-bit colors.
+func (avatarBackend) SetAttr(w io.Writer, a Attr, fg, bg RGB) {
+	writeRaw(w, []byte{avtCmd, avtAttr, byte(a &^ attrBlink)})
+	if a&attrBlink != 0 {
+		writeRaw(w, []byte{avtCmd, avtBlink})
+	}
+}
+
+// Repeat uses ^Y for long runs. The character goes through the charset
+// like any other text; the count doesn't.
+func (avatarBackend) Repeat(w io.Writer, ch rune, n int) {
+	for n > 0 {
+		run := min(n, avtMaxRun)
+		if run < avtMinRun {
+			io.WriteString(w, strings.Repeat(string(ch), run))
+		} else {
+			writeRaw(w, []byte{avtRepeat})
+			io.WriteString(w, string(ch))
+			writeRaw(w, []byte{byte(run)})
+		}
+		n -= run
+	}
+}
+
+// Cursor does nothing; Avatar/0 can't hide the cursor.
+func (avatarBackend) Cursor(w io.Writer, visible bool) {}
+
+// speaksANSI reports whether a drop file emulation value means ANSI escape
+// sequences are understood. 0 is plain ASCII and 2 is Avatar.
+func speaksANSI(emulation int) bool {
+	return emulation != 0 && emulation != 2
+}
+
+// backendFor picks the screen backend for a drop file emulation value and
+// the capabilities the terminal reported.
+func backendFor(emulation int, c Caps) Backend {
+	if emulation == 2 {
+		return avatarBackend{}
+	}
+	// SyncTERM does iCE colors with its own mode switch instead
+	return ansiBackend{TrueColor: c.TrueColor, Aixterm: c.ICEColors && c.Terminal != "SyncTERM"}
+}
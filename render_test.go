@@ -0,0 +1,51 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestAvatarCodesSkipCharset(t *testing.T) {
+	for _, cs := range []Charset{CharsetUTF8, CharsetCP437} {
+		var out bytes.Buffer
+		tm := NewTerminal(nil, &out, cs)
+		avatarBackend{}.MoveTo(tm, 200, 30)
+		avatarBackend{}.SetAttr(tm, 0x1e, 0, 0)
+		want := []byte{avtCmd, avtGoto, 30, 200, avtCmd, avtAttr, 0x1e}
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Errorf("%v: sent % x, want % x", cs, out.Bytes(), want)
+		}
+	}
+
+	// In a telnet session 0xff still has to be doubled.
+	var out bytes.Buffer
+	tm := NewTerminal(nil, &out, CharsetCP437)
+	tm.Telnet = true
+	avatarBackend{}.MoveTo(tm, 255, 1)
+	if want := []byte{avtCmd, avtGoto, 1, telnetIAC, telnetIAC}; !bytes.Equal(out.Bytes(), want) {
+		t.Errorf("telnet: sent % x, want % x", out.Bytes(), want)
+	}
+}
+
+func TestAvatarFlushWideScreen(t *testing.T) {
+	var out bytes.Buffer
+	tm := NewTerminal(nil, &out, CharsetCP437)
+	s := NewScreen(80, 2)
+	s.Out = avatarBackend{}
+	s.Place(320, 25) // centered 120 columns in
+	s.PrintAt(11, 1, "é═════")
+	if err := s.Flush(tm); err != nil {
+		t.Fatal(err)
+	}
+
+	got := out.Bytes()
+	if want := []byte{avtCmd, avtGoto, 1, 131}; !bytes.Contains(got, want) {
+		t.Errorf("no move to column 131 in % x", got)
+	}
+	if want := []byte{0x82, avtRepeat, 0xcd, 5}; !bytes.Contains(got, want) {
+		t.Errorf("the text isn't in CP437 in % x", got)
+	}
+	if bytes.IndexByte(got, '?') >= 0 {
+		t.Errorf("something turned into '?': % x", got)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+
+	"github.com/eiannone/keyboard"
+)
+
+// repliesTo returns the visible replies to message id, oldest first.
+func repliesTo(id int) []Message {
+	replies, err := store.Replies(id)
+	if err != nil {
+		panic(err)
+	}
+	return replies
+}
+
+// replyToMessage lets the caller scrawl a reply underneath the message on
+// screen, then shows it with the other replies.
+func replyToMessage() {
+	parent := currentMessage()
+	if parent == nil {
+		return
+	}
+	if composePost(parent.ID) {
+		viewReplies(*parent, len(repliesTo(parent.ID))-1)
+	}
+	showCurrent()
+}
+
+// readReplies shows the replies to the message on screen.
+func readReplies() {
+	m := currentMessage()
+	if m == nil {
+		return
+	}
+	if m.Replies == 0 {
+		ui.Notice("No replies yet.")
+	} else {
+		viewReplies(*m, 0)
+	}
+	showCurrent()
+}
+
+// viewReplies steps through the replies to parent, starting at reply i.
+// The wall's keys work the same way on replies, and Q goes back to the wall.
+func viewReplies(parent Message, i int) {
+	for {
+		replies := repliesTo(parent.ID)
+		if len(replies) == 0 {
+			return
+		}
+		i = clamp(i, 0, len(replies)-1)
+		ui.ShowReply(&parent, &replies[i], i+1, len(replies))
+		ui.ShowMenu()
+
+		char, key := getKey()
+		if key == keyboard.KeyEsc {
+			return
+		}
+		switch strings.ToUpper(string(char)) {
+		case "N":
+			i++
+		case "P":
+			i--
+		case "F":
+			i = 0
+		case "L":
+			i = len(replies) - 1
+		case "K", "X":
+			castVote(replies[i].ID, strings.ToUpper(string(char)) == "X")
+		case "A", "R":
+			if composePost(parent.ID) {
+				i = len(replies) // the new reply is the last one
+			}
+		case "Q":
+			return
+		}
+	}
+}
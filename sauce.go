@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// SAUCE record layout, see https://www.acid.org/info/sauce/sauce.htm
+const (
+	sauceSize        = 128
+	sauceCommentSize = 64
+	sauceID          = "SAUCE"
+	sauceCommentID   = "COMNT"
+	sauceEOF         = 0x1a
+
+	sauceDataCharacter = 1
+	sauceFileANSi      = 1
+)
+
+// Sauce is the metadata trailer that art editors append to .ans files.
+type Sauce struct {
+	Version  string
+	Title    string
+	Author   string
+	Group    string
+	Date     string // CCYYMMDD
+	FileSize uint32
+	DataType byte
+	FileType byte
+	TInfo1   uint16
+	TInfo2   uint16
+	TInfo3   uint16
+	TInfo4   uint16
+	Flags    byte
+	Font     string // TInfoS, e.g. "IBM VGA" or "Amiga Topaz 2+"
+	Comments []string
+}
+
+// Art is an art file split into its displayable text and SAUCE record.
+// Text has been converted from CP437 to UTF-8.
+type Art struct {
+	Text  string
+	Sauce *Sauce // nil when the file has no SAUCE record
+}
+
+// ReadArt reads an art file and parses its SAUCE record, if any.
+func ReadArt(filePath string) (*Art, error) {
+	content, err := os.ReadFile(filePath)
+	if err != nil {
+		return nil, err
+	}
+	data, sauce := ParseSauce(content)
+	return &Art{Text: decodeCP437(data), Sauce: sauce}, nil
+}
+
+// Rows splits the art into screen rows the way a terminal as wide as its
+// SAUCE width lays it out: at each line break, and wherever a row runs past
+// the right margin and wraps. Rows past its SAUCE height are dropped.
+func (a *Art) Rows() []string {
+	width := a.Sauce.Width()
+	var rows []string
+	for _, line := range strings.Split(a.Text, "\r\n") {
+		rows = append(rows, wrapArtRow(line, width)...)
+	}
+	if h := a.Sauce.Height(); h > 0 && h < len(rows) {
+		rows = rows[:h]
+	}
+	return rows
+}
+
+// wrapArtRow breaks one line of art into rows of width columns. Escape
+// sequences take no room, except cursor forward, which moves across the row
+// and stops at the margin as it does on a terminal.
+func wrapArtRow(line string, width int) []string {
+	var rows []string
+	start, col := 0, 0
+	for i := 0; i < len(line); {
+		if line[i] == 0x1b && i+1 < len(line) && line[i+1] == '[' {
+			j := i + 2
+			for j < len(line) && (line[j] < 0x40 || line[j] > 0x7e) {
+				j++
+			}
+			if j < len(line) && line[j] == 'C' {
+				n, err := strconv.Atoi(line[i+2 : j])
+				if err != nil || n < 1 {
+					n = 1
+				}
+				if col += n; col > width-1 {
+					col = width - 1
+				}
+			}
+			i = j + 1
+			continue
+		}
+		_, size := utf8.DecodeRuneInString(line[i:])
+		if col == width {
+			rows = append(rows, line[start:i])
+			start, col = i, 0
+		}
+		col++
+		i += size
+	}
+	return append(rows, line[start:])
+}
+
+// ParseSauce reads the 128-byte SAUCE record and optional comment block from
+// the end of content. It returns the art data without the metadata or the
+// EOF marker in front of it. Content without a valid record comes back
+// unchanged with a nil Sauce.
+func ParseSauce(content []byte) ([]byte, *Sauce) {
+	if len(content) < sauceSize {
+		return content, nil
+	}
+	rec := content[len(content)-sauceSize:]
+	if string(rec[0:5]) != sauceID {
+		return content, nil
+	}
+
+	s := &Sauce{
+		Version:  string(rec[5:7]),
+		Title:    sauceString(rec[7:42]),
+		Author:   sauceString(rec[42:62]),
+		Group:    sauceString(rec[62:82]),
+		Date:     sauceString(rec[82:90]),
+		FileSize: binary.LittleEndian.Uint32(rec[90:94]),
+		DataType: rec[94],
+		FileType: rec[95],
+		TInfo1:   binary.LittleEndian.Uint16(rec[96:98]),
+		TInfo2:   binary.LittleEndian.Uint16(rec[98:100]),
+		TInfo3:   binary.LittleEndian.Uint16(rec[100:102]),
+		TInfo4:   binary.LittleEndian.Uint16(rec[102:104]),
+		Flags:    rec[105],
+		Font:     sauceString(rec[106:128]),
+	}
+	end := len(content) - sauceSize
+
+	// The comment block sits directly in front of the record, but only
+	// trust it if its ID is where the count says it should be.
+	if n := int(rec[104]); n > 0 {
+		start := end - len(sauceCommentID) - n*sauceCommentSize
+		if start >= 0 && string(content[start:start+len(sauceCommentID)]) == sauceCommentID {
+			lines := content[start+len(sauceCommentID) : end]
+			for i := 0; i < n; i++ {
+				s.Comments = append(s.Comments, sauceString(lines[i*sauceCommentSize:(i+1)*sauceCommentSize]))
+			}
+			end = start
+		}
+	}
+
+	if end > 0 && content[end-1] == sauceEOF {
+		end--
+	}
+	return content[:end], s
+}
+
+// sauceString converts a space or NUL padded CP437 field to UTF-8.
+func sauceString(b []byte) string {
+	if idx := bytes.IndexByte(b, 0); idx != -1 {
+		b = b[:idx]
+	}
+	return strings.TrimRight(decodeCP437(b), " ")
+}
+
+// isCharacterArt reports whether TInfo1 and TInfo2 hold character
+// dimensions, which is the case for ASCII, ANSi and similar text files.
+func (s *Sauce) isCharacterArt() bool {
+	return s.DataType == sauceDataCharacter && s.FileType <= 2 // ASCII, ANSi, ANSiMation
+}
+
+// Width is the art's width in characters, 80 when unknown.
+func (s *Sauce) Width() int {
+	if s == nil || !s.isCharacterArt() || s.TInfo1 == 0 {
+		return 80
+	}
+	return int(s.TInfo1)
+}
+
+// Height is the art's height in lines, 0 when unknown.
+func (s *Sauce) Height() int {
+	if s == nil || !s.isCharacterArt() {
+		return 0
+	}
+	return int(s.TInfo2)
+}
+
+// ICEColors reports whether the blink attribute means a bright background.
+func (s *Sauce) ICEColors() bool {
+	return s != nil && s.Flags&0x01 != 0
+}
+
+// LetterSpacing is the font width the art was drawn for: 8 or 9 pixels, or
+// 0 when the artist didn't say.
+func (s *Sauce) LetterSpacing() int {
+	if s == nil {
+		return 0
+	}
+	switch (s.Flags >> 1) & 0x03 {
+	case 1:
+		return 8
+	case 2:
+		return 9
+	}
+	return 0
+}
+
+// LegacyAspect reports whether the art expects the stretched pixels of a
+// CRT rather than square ones.
+func (s *Sauce) LegacyAspect() bool {
+	return s != nil && (s.Flags>>3)&0x03 == 1
+}
+
+// FontSequence returns the SyncTERM font switch for the art's SAUCE font,
+// or "" for the IBM fonts every terminal starts with.
+func (s *Sauce) FontSequence() string {
+	if s == nil {
+		return ""
+	}
+	switch s.Font {
+	case "Amiga Topaz 1", "Amiga Topaz 2":
+		return Topaz
+	case "Amiga Topaz 1+", "Amiga Topaz 2+":
+		return Topazplus
+	case "Amiga MicroKnight":
+		return Microknight
+	case "Amiga MicroKnight+":
+		return Microknightplus
+	case "Amiga mOsOul":
+		return Mosoul
+	case "Amiga P0T-NOoDLE":
+		return Potnoodle
+	}
+	return ""
+}
+
+// AppendSauce appends the EOF marker, comment block and SAUCE record for s
+// to art data. FileSize is filled in from data.
+func AppendSauce(data []byte, s *Sauce) []byte {
+	out := append(append([]byte(nil), data...), sauceEOF)
+	if len(s.Comments) > 0 {
+		out = append(out, sauceCommentID...)
+		for _, c := range s.Comments {
+			out = append(out, sauceField(c, sauceCommentSize)...)
+		}
+	}
+
+	rec := make([]byte, sauceSize)
+	copy(rec[0:5], sauceID)
+	copy(rec[5:7], "00")
+	copy(rec[7:42], sauceField(s.Title, 35))
+	copy(rec[42:62], sauceField(s.Author, 20))
+	copy(rec[62:82], sauceField(s.Group, 20))
+	copy(rec[82:90], sauceField(s.Date, 8))
+	binary.LittleEndian.PutUint32(rec[90:94], uint32(len(data)))
+	rec[94] = s.DataType
+	rec[95] = s.FileType
+	binary.LittleEndian.PutUint16(rec[96:98], s.TInfo1)
+	binary.LittleEndian.PutUint16(rec[98:100], s.TInfo2)
+	binary.LittleEndian.PutUint16(rec[100:102], s.TInfo3)
+	binary.LittleEndian.PutUint16(rec[102:104], s.TInfo4)
+	rec[104] = byte(len(s.Comments))
+	rec[105] = s.Flags
+	copy(rec[106:128], s.Font) // TInfoS is NUL padded
+	return append(out, rec...)
+}
+
+// sauceField converts text to a CP437 field of n bytes, padded with spaces.
+func sauceField(text string, n int) []byte {
+	b := bytes.Repeat([]byte{' '}, n)
+	i := 0
+	for _, r := range text {
+		if i == n {
+			break
+		}
+		b[i] = CharsetCP437.encodeRune(r)
+		i++
+	}
+	return b
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseSauceArt(t *testing.T) {
+	tests := []struct {
+		path   string
+		height int
+		size   int // of the art without its metadata
+	}{
+		{"themes/classic/toiletui.ans", 24, 1801},
+		{"art/toiletempty.ans", 21, 1509},
+	}
+	for _, tt := range tests {
+		content, err := os.ReadFile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, s := ParseSauce(content)
+		if s == nil {
+			t.Fatalf("%s: no SAUCE record", tt.path)
+		}
+		if s.Title != "" || s.Author != "Anonymous" || s.Date != "20240124" || s.Font != "IBM VGA" {
+			t.Errorf("%s: title %q, author %q, date %q, font %q", tt.path, s.Title, s.Author, s.Date, s.Font)
+		}
+		if s.Width() != 80 || s.Height() != tt.height {
+			t.Errorf("%s: %dx%d, want 80x%d", tt.path, s.Width(), s.Height(), tt.height)
+		}
+		if s.Flags != 0x02 || s.ICEColors() || s.LetterSpacing() != 8 || s.LegacyAspect() {
+			t.Errorf("%s: flags %#x", tt.path, s.Flags)
+		}
+		if s.FontSequence() != "" {
+			t.Errorf("%s: font switch %q for an IBM font", tt.path, s.FontSequence())
+		}
+		if len(data) != tt.size || int(s.FileSize) != tt.size {
+			t.Errorf("%s: %d bytes of art, SAUCE says %d, want %d", tt.path, len(data), s.FileSize, tt.size)
+		}
+		if bytes.IndexByte(data, sauceEOF) >= 0 || bytes.Contains(data, []byte(sauceID)) {
+			t.Errorf("%s: the EOF marker or record is still in the art", tt.path)
+		}
+	}
+}
+
+func TestParseSauceComments(t *testing.T) {
+	full, err := os.ReadFile("art/toiletempty.ans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	art, _ := ParseSauce(full)
+	rec := append([]byte(nil), full[len(full)-sauceSize:]...)
+	rec[104] = 2 // comment lines
+
+	// The art, the EOF marker, then the comment block in front of the record.
+	content := append(append([]byte(nil), art...), sauceEOF)
+	content = append(content, sauceCommentID...)
+	for _, c := range []string{"Drawn for the stall", "Wash your hands"} {
+		content = append(content, c+strings.Repeat(" ", sauceCommentSize-len(c))...)
+	}
+	content = append(content, rec...)
+
+	data, s := ParseSauce(content)
+	if s == nil {
+		t.Fatal("no SAUCE record")
+	}
+	want := []string{"Drawn for the stall", "Wash your hands"}
+	if !reflect.DeepEqual(s.Comments, want) {
+		t.Errorf("comments %q, want %q", s.Comments, want)
+	}
+	if !bytes.Equal(data, art) {
+		t.Errorf("got %d bytes of art back, want %d", len(data), len(art))
+	}
+	if bytes.Contains(data, []byte(sauceCommentID)) {
+		t.Error("the comment block is still in the art")
+	}
+}
+
+func TestArtRows(t *testing.T) {
+	for _, path := range []string{"themes/classic/toiletui.ans", "art/toiletempty.ans"} {
+		full, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		art, err := ReadArt(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rows := art.Rows()
+		if len(rows) != art.Sauce.Height() {
+			t.Errorf("%s: %d rows, want %d", path, len(rows), art.Sauce.Height())
+		}
+
+		// The same art with a record that says it is 40 columns wide wraps
+		// each row that is wider than that.
+		narrow := append([]byte(nil), full...)
+		binary.LittleEndian.PutUint16(narrow[len(narrow)-sauceSize+96:], 40)
+		binary.LittleEndian.PutUint16(narrow[len(narrow)-sauceSize+98:], 0)
+		data, s := ParseSauce(narrow)
+		wrapped := (&Art{Text: decodeCP437(data), Sauce: s}).Rows()
+		if len(wrapped) <= len(rows) {
+			t.Errorf("%s: %d rows at 40 columns, %d at 80", path, len(wrapped), len(rows))
+		}
+		if strings.Join(wrapped, "") != strings.Join(rows, "") {
+			t.Errorf("%s: wrapping changed the art", path)
+		}
+		for i, row := range wrapped {
+			if r := wrapArtRow(row, 40); len(r) != 1 {
+				t.Errorf("%s: row %d is wider than 40 columns", path, i)
+			}
+		}
+	}
+}
+
+func TestWrapArtRow(t *testing.T) {
+	tests := []struct {
+		line  string
+		width int
+		want  []string
+	}{
+		{"", 80, []string{""}},
+		{"abcdef", 6, []string{"abcdef"}},
+		{"abcdef", 3, []string{"abc", "def"}},
+		{"\x1b[1;31mabcd\x1b[0m", 2, []string{"\x1b[1;31mab", "cd\x1b[0m"}},
+		{"ab\x1b[5Ccd", 8, []string{"ab\x1b[5Cc", "d"}},
+		{"ab\x1b[9Cc", 4, []string{"ab\x1b[9Cc"}}, // stops at the margin
+		{"\x1b[C", 1, []string{"\x1b[C"}},
+		{"░▒▓█", 2, []string{"░▒", "▓█"}},
+	}
+	for _, tt := range tests {
+		if got := wrapArtRow(tt.line, tt.width); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("wrapArtRow(%q, %d) = %q, want %q", tt.line, tt.width, got, tt.want)
+		}
+	}
+}
+
+func TestParseSauceMissing(t *testing.T) {
+	full, err := os.ReadFile("art/toiletempty.ans")
+	if err != nil {
+		t.Fatal(err)
+	}
+	art, _ := ParseSauce(full)
+
+	tests := []struct {
+		name    string
+		content []byte
+	}{
+		{"no record", art},
+		{"short file", []byte("hi\x1a")},
+		{"truncated record", full[:len(full)-40]},
+	}
+	for _, tt := range tests {
+		data, s := ParseSauce(tt.content)
+		if s != nil {
+			t.Errorf("%s: found a record: %+v", tt.name, s)
+		}
+		if !bytes.Equal(data, tt.content) {
+			t.Errorf("%s: the content changed", tt.name)
+		}
+		if s.Width() != 80 || s.Height() != 0 || s.FontSequence() != "" {
+			t.Errorf("%s: a missing record doesn't give the defaults", tt.name)
+		}
+	}
+
+	// A comment count with no comment block in front of the record is
+	// ignored rather than eating the art.
+	bad := append([]byte(nil), full...)
+	bad[len(bad)-sauceSize+104] = 3
+	data, s := ParseSauce(bad)
+	if s == nil || s.Comments != nil {
+		t.Fatalf("got %+v, want the record without comments", s)
+	}
+	if !bytes.Equal(data, art) {
+		t.Errorf("got %d bytes of art back, want %d", len(data), len(art))
+	}
+}
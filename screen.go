@@ -0,0 +1,608 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// Attr is a PC text attribute: foreground in the low nibble (8-15 are the
+// bright colors), background in bits 4-6 and blink, or bright background
+// with iCE colors, in bit 7.
+type Attr uint8
+
+const (
+	DefaultAttr Attr = 0x07
+	attrBlink   Attr = 0x80
+)
+
+// ansiColor maps PC color numbers to ANSI SGR color offsets.
+var ansiColor = [8]int{0, 4, 2, 6, 1, 5, 3, 7}
+
+func (a Attr) fg() int { return int(a & 0x0f) }
+func (a Attr) bg() int { return int(a>>4) & 0x07 }
+
+// sgr returns the escape sequence that selects the attribute from scratch.
+func (a Attr) sgr() string {
+	seq := Esc + "0"
+	if a.fg() >= 8 {
+		seq += ";1"
+	}
+	if a&attrBlink != 0 {
+		seq += ";5"
+	}
+	return seq + ";" + strconv.Itoa(30+ansiColor[a.fg()&7]) + ";" + strconv.Itoa(40+ansiColor[a.bg()]) + "m"
+}
+
+// RGB is a 24-bit color for terminals that can show one. The zero value
+// means no 24-bit color was given and the Attr color is used.
+type RGB uint32
+
+const rgbSet RGB = 1 << 24
+
+func rgb(r, g, b int) RGB {
+	return rgbSet | RGB(r&0xff)<<16 | RGB(g&0xff)<<8 | RGB(b&0xff)
+}
+
+func (c RGB) components() (r, g, b int) {
+	return int(c>>16) & 0xff, int(c>>8) & 0xff, int(c) & 0xff
+}
+
+// pcPalette is the VGA palette, for matching 24-bit colors to PC colors.
+var pcPalette = [16]RGB{
+	0x000000, 0x0000aa, 0x00aa00, 0x00aaaa, 0xaa0000, 0xaa00aa, 0xaa5500, 0xaaaaaa,
+	0x555555, 0x5555ff, 0x55ff55, 0x55ffff, 0xff5555, 0xff55ff, 0xffff55, 0xffffff,
+}
+
+// nearestPC returns the closest of the first n PC colors to c.
+func nearestPC(c RGB, n int) int {
+	r, g, b := c.components()
+	best, bestDist := 0, -1
+	for i, p := range pcPalette[:n] {
+		pr, pg, pb := p.components()
+		d := (r-pr)*(r-pr) + (g-pg)*(g-pg) + (b-pb)*(b-pb)
+		if bestDist < 0 || d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// xterm256 converts an xterm 256-color palette index to 24-bit color.
+func xterm256(n int) RGB {
+	switch {
+	case n < 16:
+		return rgbSet | pcPalette[pcColor(n&7)+(n&8)]
+	case n < 232:
+		n -= 16
+		level := func(v int) int {
+			if v == 0 {
+				return 0
+			}
+			return 55 + v*40
+		}
+		return rgb(level(n/36), level(n/6%6), level(n%6))
+	default:
+		v := 8 + (n-232)*10
+		return rgb(v, v, v)
+	}
+}
+
+// Cell is one character position on the screen. Fg and Bg carry 24-bit
+// colors when the text asked for them; Attr always holds the nearest PC
+// colors, for terminals that can't show them.
+type Cell struct {
+	Ch     rune
+	Attr   Attr
+	Fg, Bg RGB
+}
+
+var blankCell = Cell{Ch: ' ', Attr: DefaultAttr}
+
+// wideTail marks the cell under the right half of a wide character, like
+// 漢. It's never sent; the terminal fills it when it draws the character.
+const wideTail rune = -1
+
+// Screen is an in-memory copy of the caller's screen. Art and widgets are
+// drawn into it by writing ANSI text, and Flush sends only the cells that
+// changed since the last flush, encoded by the screen's Backend.
+type Screen struct {
+	W, H int
+	Out  Backend // how changes are encoded for the caller's terminal
+
+	// Where the buffer's top-left corner lands on the caller's screen, so a
+	// wider terminal shows the stall centered.
+	OffsetX, OffsetY int
+
+	cells []Cell
+	base  []Cell // the background that widget regions are restored from
+	sent  []Cell // what the caller's terminal shows; nil means unknown
+
+	// ANSI parser state
+	x, y   int
+	attr   Attr
+	fg, bg RGB
+	savedX int
+	savedY int
+	esc    []byte // an escape sequence split across writes
+	utf    []byte // a rune split across writes
+}
+
+// NewScreen creates a blank w x h screen.
+func NewScreen(w, h int) *Screen {
+	s := &Screen{W: w, H: h, Out: ansiBackend{}}
+	s.cells = make([]Cell, w*h)
+	s.Clear()
+	return s
+}
+
+// Clear blanks the buffer and homes the drawing cursor. Nothing is sent
+// until the next Flush.
+func (s *Screen) Clear() {
+	for i := range s.cells {
+		s.cells[i] = blankCell
+	}
+	s.x, s.y = 0, 0
+	s.attr = DefaultAttr
+	s.fg, s.bg = 0, 0
+}
+
+// Invalidate forgets what the terminal shows, so the next Flush clears it
+// and repaints everything.
+func (s *Screen) Invalidate() {
+	s.sent = nil
+}
+
+// Place positions the buffer on a caller's screen of the given size,
+// centering it horizontally, and forces a full repaint.
+func (s *Screen) Place(termW, termH int) {
+	s.OffsetX = 0
+	if termW > s.W {
+		s.OffsetX = (termW - s.W) / 2
+	}
+	s.OffsetY = 0
+	s.Invalidate()
+}
+
+// MoveCursor moves the caller's cursor to the 1-based column x and row y of
+// the buffer.
+func (s *Screen) MoveCursor(w io.Writer, x, y int) {
+	s.Out.MoveTo(w, x+s.OffsetX, y+s.OffsetY)
+}
+
+// SaveBackground keeps the current contents, usually freshly drawn art, as
+// the layer that RestoreBackground composites back in.
+func (s *Screen) SaveBackground() {
+	s.base = append(s.base[:0], s.cells...)
+}
+
+// RestoreBackground copies the saved background back over a w x h region
+// at the 1-based column x and row y, erasing whatever a widget drew there.
+func (s *Screen) RestoreBackground(x, y, w, h int) {
+	for row := y - 1; row < y-1+h; row++ {
+		for col := x - 1; col < x-1+w; col++ {
+			if col < 0 || row < 0 || col >= s.W || row >= s.H {
+				continue
+			}
+			i := row*s.W + col
+			if i < len(s.base) {
+				s.cells[i] = s.base[i]
+			} else {
+				s.cells[i] = blankCell
+			}
+		}
+	}
+}
+
+// Cell returns the cell at the 1-based column x and row y.
+func (s *Screen) Cell(x, y int) Cell {
+	if x < 1 || y < 1 || x > s.W || y > s.H {
+		return blankCell
+	}
+	return s.cells[(y-1)*s.W+x-1]
+}
+
+// PrintAt draws ANSI text starting at the 1-based column x and row y.
+func (s *Screen) PrintAt(x, y int, text string) {
+	s.x, s.y = x-1, y-1
+	io.WriteString(s, text)
+}
+
+func (s *Screen) set(x, y int, c Cell) {
+	if x < 0 || y < 0 || x >= s.W || y >= s.H {
+		return
+	}
+	s.cells[y*s.W+x] = c
+}
+
+// Write interprets UTF-8 ANSI text into the buffer.
+func (s *Screen) Write(p []byte) (int, error) {
+	data := p
+	if len(s.utf) > 0 {
+		data = append(s.utf, p...)
+		s.utf = nil
+	}
+
+	for len(data) > 0 {
+		if s.esc != nil {
+			b := data[0]
+			data = data[1:]
+			s.esc = append(s.esc, b)
+			s.escape()
+			continue
+		}
+
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && !utf8.FullRune(data) {
+			s.utf = append([]byte(nil), data...)
+			break
+		}
+		data = data[size:]
+		s.put(r)
+	}
+	return len(p), nil
+}
+
+// put handles one character outside an escape sequence.
+func (s *Screen) put(r rune) {
+	switch r {
+	case 0x1b:
+		s.esc = []byte{}
+	case '\r':
+		s.x = 0
+	case '\n':
+		s.lineFeed()
+	case '\b':
+		if s.x > 0 {
+			s.x--
+		}
+	case '\t':
+		s.x = (s.x/8 + 1) * 8
+		if s.x >= s.W {
+			s.x = s.W - 1
+		}
+	case 0x1a, 0x07, 0:
+		// EOF marker, bell and NUL don't draw anything
+	default:
+		w := layout.RuneWidth(r)
+		if w == 0 {
+			return // combining marks and the like get no cell of their own
+		}
+		// The cursor waits in the last column until something is printed,
+		// so a full-width row followed by CR/LF doesn't skip a line. A wide
+		// character that won't fit goes to the next line, as on a terminal.
+		if s.x+w > s.W {
+			s.x = 0
+			s.lineFeed()
+		}
+		s.unsplit(s.x, s.y, w)
+		s.set(s.x, s.y, s.pen(r))
+		if w == 2 {
+			s.set(s.x+1, s.y, s.pen(wideTail))
+		}
+		s.x += w
+	}
+}
+
+// unsplit blanks what's left of any wide character that drawing w columns
+// at x, y would cut in half.
+func (s *Screen) unsplit(x, y, w int) {
+	if x > 0 && s.Cell(x+1, y+1).Ch == wideTail {
+		c := s.Cell(x, y+1)
+		c.Ch = ' '
+		s.set(x-1, y, c)
+	}
+	if c := s.Cell(x+w+1, y+1); c.Ch == wideTail {
+		c.Ch = ' '
+		s.set(x+w, y, c)
+	}
+}
+
+// pen returns a cell drawn with the current colors.
+func (s *Screen) pen(r rune) Cell {
+	return Cell{Ch: r, Attr: s.attr, Fg: s.fg, Bg: s.bg}
+}
+
+// lineFeed moves down a row, scrolling the buffer at the bottom.
+func (s *Screen) lineFeed() {
+	s.y++
+	if s.y < s.H {
+		return
+	}
+	s.y = s.H - 1
+	copy(s.cells, s.cells[s.W:])
+	for i := len(s.cells) - s.W; i < len(s.cells); i++ {
+		s.cells[i] = blankCell
+	}
+}
+
+// escape runs the pending escape sequence once it is complete.
+func (s *Screen) escape() {
+	seq := s.esc
+	if len(seq) == 1 {
+		if seq[0] != '[' {
+			s.esc = nil // not a CSI; ignore it
+		}
+		return
+	}
+	final := seq[len(seq)-1]
+	if final < 0x40 || final > 0x7e {
+		return // still reading parameters
+	}
+	s.esc = nil
+
+	params := string(seq[1 : len(seq)-1])
+	if strings.HasPrefix(params, "?") || strings.ContainsAny(params, " =<>") {
+		return // private modes and SyncTERM extensions don't touch cells
+	}
+	args := parseParams(params)
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	switch final {
+	case 'H', 'f':
+		s.y, s.x = clamp(arg(0, 1)-1, 0, s.H-1), clamp(arg(1, 1)-1, 0, s.W-1)
+	case 'A':
+		s.y = clamp(s.y-arg(0, 1), 0, s.H-1)
+	case 'B':
+		s.y = clamp(s.y+arg(0, 1), 0, s.H-1)
+	case 'C':
+		s.x = clamp(s.x+arg(0, 1), 0, s.W-1)
+	case 'D':
+		s.x = clamp(s.x-arg(0, 1), 0, s.W-1)
+	case 'G':
+		s.x = clamp(arg(0, 1)-1, 0, s.W-1)
+	case 'd':
+		s.y = clamp(arg(0, 1)-1, 0, s.H-1)
+	case 's':
+		s.savedX, s.savedY = s.x, s.y
+	case 'u':
+		s.x, s.y = s.savedX, s.savedY
+	case 'J':
+		s.eraseDisplay(arg(0, 0))
+	case 'K':
+		s.eraseLine(arg(0, 0))
+	case 'm':
+		s.selectGraphics(args)
+	}
+}
+
+func (s *Screen) eraseDisplay(mode int) {
+	blank := s.pen(' ')
+	start, end := 0, len(s.cells)
+	pos := s.y*s.W + clamp(s.x, 0, s.W-1)
+	switch mode {
+	case 0:
+		start = pos
+	case 1:
+		end = pos + 1
+	default:
+		s.x, s.y = 0, 0 // ANSI.SYS homes the cursor on a full clear
+	}
+	for i := start; i < end; i++ {
+		s.cells[i] = blank
+	}
+}
+
+func (s *Screen) eraseLine(mode int) {
+	from, to := 0, s.W
+	switch mode {
+	case 0:
+		from = s.x
+	case 1:
+		to = s.x + 1
+	}
+	for x := from; x < to; x++ {
+		s.set(x, s.y, s.pen(' '))
+	}
+}
+
+// selectGraphics applies an SGR sequence to the drawing attribute. 24-bit
+// and 256-color codes set the cell's true color and the nearest PC color.
+func (s *Screen) selectGraphics(args []int) {
+	if len(args) == 0 {
+		args = []int{0}
+	}
+	for i := 0; i < len(args); i++ {
+		switch a := args[i]; {
+		case a == 0:
+			s.attr = DefaultAttr
+			s.fg, s.bg = 0, 0
+		case a == 1:
+			s.attr |= 0x08
+		case a == 2 || a == 22:
+			s.attr &^= 0x08
+		case a == 5 || a == 6:
+			s.attr |= attrBlink
+		case a == 25:
+			s.attr &^= attrBlink
+		case a == 7:
+			s.attr = s.attr&(0x08|attrBlink) | Attr(s.attr.bg()) | Attr(s.attr.fg()&7)<<4
+			s.fg, s.bg = s.bg, s.fg
+		case a >= 30 && a <= 37:
+			s.attr = s.attr&^0x07 | Attr(pcColor(a-30))
+			s.fg = 0
+		case a == 39:
+			s.attr = s.attr&^0x07 | 0x07
+			s.fg = 0
+		case a >= 40 && a <= 47:
+			s.attr = s.attr&^0x70 | Attr(pcColor(a-40))<<4
+			s.bg = 0
+		case a == 49:
+			s.attr &^= 0x70
+			s.bg = 0
+		case a >= 90 && a <= 97:
+			s.attr = s.attr&^0x0f | Attr(pcColor(a-90)) | 0x08
+			s.fg = 0
+		case a >= 100 && a <= 107:
+			s.attr = s.attr&^0x70 | Attr(pcColor(a-100))<<4 | attrBlink
+			s.bg = 0
+		case a == 38 || a == 48:
+			c, used := extendedColor(args[i+1:])
+			i += used
+			if c == 0 {
+				continue
+			}
+			if a == 38 {
+				s.fg = c
+				s.attr = s.attr&^0x0f | Attr(nearestPC(c, 16))
+			} else {
+				s.bg = c
+				s.attr = s.attr&^(0x70|attrBlink) | Attr(nearestPC(c, 8))<<4
+			}
+		}
+	}
+}
+
+// extendedColor reads the arguments after a 38 or 48: 2;r;g;b or 5;n. It
+// returns the color, or zero if it's malformed, and how many arguments it
+// used.
+func extendedColor(args []int) (RGB, int) {
+	if len(args) == 0 {
+		return 0, 0
+	}
+	switch args[0] {
+	case 2:
+		if len(args) < 4 {
+			return 0, len(args)
+		}
+		return rgb(args[1], args[2], args[3]), 4
+	case 5:
+		if len(args) < 2 {
+			return 0, len(args)
+		}
+		return xterm256(args[1] & 0xff), 2
+	}
+	return 0, 1
+}
+
+// pcColor converts an ANSI color offset to a PC color number. The mapping
+// swaps red and blue, so it is its own inverse.
+func pcColor(ansi int) int {
+	return ansiColor[ansi&7]
+}
+
+// Flush sends the cells that differ from what the terminal already shows.
+func (s *Screen) Flush(w io.Writer) error {
+	out := &frame{cs: CharsetUTF8}
+	if t, ok := w.(*Terminal); ok {
+		out.cs = t.Charset
+	}
+
+	if s.sent == nil || len(s.sent) != len(s.cells) {
+		s.Out.Clear(out)
+		s.sent = make([]Cell, len(s.cells))
+		for i := range s.sent {
+			s.sent[i] = blankCell
+		}
+	}
+
+	// The last cell is never written; that would scroll some terminals.
+	last := len(s.cells) - 1
+
+	pen, known := blankCell, false
+	curX, curY := -1, -1
+	for i := 0; i < last; {
+		c := s.cells[i]
+		x, y := i%s.W, i/s.W
+		wide := x+1 < s.W && s.cells[i+1].Ch == wideTail
+		if c.Ch == wideTail || c == s.sent[i] && (!wide || s.cells[i+1] == s.sent[i+1]) {
+			i++ // tails go out with their characters
+			continue
+		}
+
+		// Send a run of identical changed cells on this row in one go
+		n := 1
+		for !wide && x+n < s.W && i+n < last && s.cells[i+n] == c && s.sent[i+n] != c {
+			n++
+		}
+
+		if x != curX || y != curY {
+			s.MoveCursor(out, x+1, y+1)
+		}
+		if !known || c.Attr != pen.Attr || c.Fg != pen.Fg || c.Bg != pen.Bg {
+			s.Out.SetAttr(out, c.Attr, c.Fg, c.Bg)
+			pen, known = c, true
+		}
+		s.Out.Repeat(out, c.Ch, n)
+		if wide {
+			n = 2
+		}
+		for j := i; j < i+n; j++ {
+			s.sent[j] = s.cells[j]
+		}
+		i += n
+		curX, curY = x+n, y
+		if curX >= s.W || wide {
+			// A pending wrap, or a wide character a narrow charset may have
+			// sent as one column; either way, reposition.
+			curX = -1
+		}
+	}
+
+	if out.buf.Len() == 0 {
+		return nil
+	}
+	if known {
+		s.Out.SetAttr(out, DefaultAttr, 0, 0)
+	}
+	if r, ok := w.(rawWriter); ok {
+		_, err := r.WriteRaw(out.buf.Bytes())
+		return err
+	}
+	_, err := w.Write(out.buf.Bytes())
+	return err
+}
+
+// frame collects what one Flush sends, so it goes out in a single write.
+// Text is converted to the terminal's charset on the way in and control
+// codes are kept as they are.
+type frame struct {
+	buf bytes.Buffer
+	cs  Charset
+}
+
+func (f *frame) Write(p []byte) (int, error) {
+	if f.cs == CharsetUTF8 {
+		return f.buf.Write(p)
+	}
+	for _, r := range string(p) {
+		f.buf.WriteByte(f.cs.encodeRune(r))
+	}
+	return len(p), nil
+}
+
+func (f *frame) WriteRaw(p []byte) (int, error) {
+	return f.buf.Write(p)
+}
+
+// parseParams splits "1;33;44" into numbers; empty fields are zero.
+func parseParams(params string) []int {
+	if params == "" {
+		return nil
+	}
+	fields := strings.Split(params, ";")
+	args := make([]int, len(fields))
+	for i, f := range fields {
+		args[i], _ = strconv.Atoi(f)
+	}
+	return args
+}
+
+func clamp(v, lo, hi int) int {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// Search is a search of the wall, as typed at the S prompt:
+//
+//	toilet            posts containing "toilet" anywhere
+//	=toilet           posts with "toilet" as a whole word
+//	"flush it"        posts containing the phrase
+//	by:alpha          posts by alpha, on this board or another; anonymous
+//	                  posts only match by:anonymous
+//	after:2024-01-25  posts from that day on
+//	before:01/31/24   posts up to the end of that day
+//
+// Everything given has to match.
+type Search struct {
+	Query  string
+	Terms  []string // substrings, lower case
+	Words  []string // whole words, lower case
+	Author string
+	After  time.Time
+	Before time.Time // exclusive: the day after the one given
+
+	hits []int // ids of the posts it matched, oldest first
+}
+
+// search narrows N/P/F/L to the posts it matches; nil browses the whole wall.
+var search *Search
+
+// searchDateLayouts are the date forms after: and before: accept.
+var searchDateLayouts = []string{"2006-01-02", "01/02/06", "01/02/2006", "1/2/06", "1/2/2006"}
+
+// parseSearch reads a query typed at the search prompt.
+func parseSearch(query string) (*Search, error) {
+	s := &Search{Query: query}
+	for _, tok := range splitQuery(query) {
+		key, value, found := strings.Cut(tok, ":")
+		switch {
+		case found && strings.EqualFold(key, "by"):
+			s.Author = value
+		case found && strings.EqualFold(key, "after"):
+			d, err := parseSearchDate(value)
+			if err != nil {
+				return nil, err
+			}
+			s.After = d
+		case found && strings.EqualFold(key, "before"):
+			d, err := parseSearchDate(value)
+			if err != nil {
+				return nil, err
+			}
+			s.Before = d.AddDate(0, 0, 1)
+		case strings.HasPrefix(tok, "=") && len(tok) > 1:
+			s.Words = append(s.Words, strings.ToLower(tok[1:]))
+		default:
+			s.Terms = append(s.Terms, strings.ToLower(tok))
+		}
+	}
+	if len(s.Terms) == 0 && len(s.Words) == 0 && s.Author == "" && s.After.IsZero() && s.Before.IsZero() {
+		return nil, fmt.Errorf("nothing to search for")
+	}
+	return s, nil
+}
+
+func parseSearchDate(value string) (time.Time, error) {
+	for _, layout := range searchDateLayouts {
+		if d, err := time.ParseInLocation(layout, value, cfg.location()); err == nil {
+			return d, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("bad date %q", value)
+}
+
+// splitQuery splits a query on spaces, keeping quoted phrases together.
+func splitQuery(query string) []string {
+	var toks []string
+	var tok strings.Builder
+	quoted := false
+	for _, r := range query {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case r == ' ' && !quoted:
+			if tok.Len() > 0 {
+				toks = append(toks, tok.String())
+				tok.Reset()
+			}
+		default:
+			tok.WriteRune(r)
+		}
+	}
+	if tok.Len() > 0 {
+		toks = append(toks, tok.String())
+	}
+	return toks
+}
+
+// Matches reports whether m is one of the search's results.
+func (s *Search) Matches(m *Message) bool {
+	body := strings.ToLower(stripMarkup(m.Body))
+	for _, term := range s.Terms {
+		if !strings.Contains(body, term) {
+			return false
+		}
+	}
+	if len(s.Words) > 0 {
+		words := map[string]bool{}
+		for _, w := range strings.FieldsFunc(body, func(r rune) bool {
+			return !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '\''
+		}) {
+			words[w] = true
+		}
+		for _, w := range s.Words {
+			if !words[w] {
+				return false
+			}
+		}
+	}
+	if s.Author != "" && !strings.EqualFold(m.Byline(), s.Author) &&
+		!strings.EqualFold(strings.TrimSuffix(m.Byline(), "@"+m.Origin), s.Author) {
+		return false // the byline hides who posted anonymously
+	}
+	if !s.After.IsZero() && m.Posted.Before(s.After) {
+		return false
+	}
+	if !s.Before.IsZero() && !m.Posted.Before(s.Before) {
+		return false
+	}
+	return true
+}
+
+// Position returns where post id is among the posts the search matched
+// that N/P/F/L can reach, counting from 1, and how many there are. A post
+// written since the search was made is looked for again.
+func (s *Search) Position(id int) (at, matches int) {
+	i := sort.SearchInts(s.hits, id)
+	if i == len(s.hits) || s.hits[i] != id {
+		s.hits = postsAfter(0, browseFilter(s))
+		i = sort.SearchInts(s.hits, id)
+	}
+	if i < len(s.hits) && s.hits[i] == id {
+		at = i + 1
+	}
+	return at, len(s.hits)
+}
+
+// searchWall asks for a search and makes its results what N/P/F/L step
+// through. An empty search goes back to the whole wall.
+func searchWall() {
+	query := strings.TrimSpace(ui.Input("Search: ", 60))
+	if query == "" {
+		if search == nil {
+			showCurrent()
+			return
+		}
+		search = nil
+		ui.Notice("Search cleared.")
+		loadLastMessage()
+		return
+	}
+
+	s, err := parseSearch(query)
+	if err != nil {
+		ui.Notice(err.Error())
+		showCurrent()
+		return
+	}
+	s.hits = postsAfter(0, browseFilter(s))
+	if len(s.hits) == 0 {
+		ui.Notice("No matches.")
+		showCurrent()
+		return
+	}
+	search = s
+	loadFirstMessage()
+}
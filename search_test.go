@@ -0,0 +1,105 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestParseSearch(t *testing.T) {
+	day := func(d int) time.Time { return time.Date(2024, 1, d, 0, 0, 0, 0, time.Local) }
+	tests := []struct {
+		query string
+		want  Search // Query is filled in from query
+		err   bool
+	}{
+		{query: "Toilet", want: Search{Terms: []string{"toilet"}}},
+		{query: `"flush it" =Paper`, want: Search{Terms: []string{"flush it"}, Words: []string{"paper"}}},
+		{query: "by:j0HNNY  rolls", want: Search{Terms: []string{"rolls"}, Author: "j0HNNY"}},
+		{query: "after:2024-01-25 before:01/31/24", want: Search{After: day(25), Before: day(32)}},
+		{query: "before:1/2/2024", want: Search{Before: day(3)}},
+		{query: "=", want: Search{Terms: []string{"="}}},
+		{query: "after:yesterday", err: true},
+		{query: `  ""  `, err: true},
+	}
+	for _, tt := range tests {
+		s, err := parseSearch(tt.query)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: got %+v, want an error", tt.query, s)
+			}
+			continue
+		}
+		tt.want.Query = tt.query
+		if err != nil || !reflect.DeepEqual(*s, tt.want) {
+			t.Errorf("%q: got %+v, %v, want %+v", tt.query, s, err, tt.want)
+		}
+	}
+}
+
+func TestSearchMatches(t *testing.T) {
+	posted := time.Date(2024, 1, 25, 12, 0, 0, 0, time.Local)
+	m := Message{Body: "Who took the |12last|07 roll? It wasn't me.", Author: "j0HNNY", Posted: posted}
+	anon := Message{Body: "I took it", Author: "j0HNNY", Anonymous: true, Posted: posted}
+	tests := []struct {
+		query string
+		m     *Message
+		want  bool
+	}{
+		{"LAST ROLL", &m, true},
+		{`"last roll"`, &m, true},
+		{"last toilet", &m, false},
+		{"=roll", &m, true},
+		{"=rol", &m, false},
+		{"=wasn't", &m, true},
+		{"by:J0hnny", &m, true},
+		{"by:j0hnny", &anon, false},
+		{"by:anonymous", &anon, true},
+		{"after:2024-01-25", &m, true},
+		{"after:2024-01-26", &m, false},
+		{"before:2024-01-25", &m, true},
+		{"before:2024-01-24", &m, false},
+	}
+	for _, tt := range tests {
+		s, err := parseSearch(tt.query)
+		if err != nil {
+			t.Fatalf("%q: %v", tt.query, err)
+		}
+		if got := s.Matches(tt.m); got != tt.want {
+			t.Errorf("%q matches %q: %v, want %v", tt.query, tt.m.Body, got, tt.want)
+		}
+	}
+}
+
+func TestSearchPositionLeavesOutPinned(t *testing.T) {
+	savedStore, savedCfg := store, cfg
+	t.Cleanup(func() { store, cfg = savedStore, savedCfg })
+	store, cfg = newTestStore(t, "flat",
+		Message{Body: "roll call", Author: "sysop", Pinned: true},
+		Message{Body: "who took the roll", Author: "j0HNNY"},
+		Message{Body: "call me", Author: "j0HNNY"},
+	)
+
+	tests := []struct {
+		query       string
+		pinned      bool // BrowsePinned
+		id          int
+		at, matches int
+	}{
+		{"roll", false, 2, 1, 1},
+		{"roll", true, 2, 2, 2},
+		{"roll call", false, 1, 0, 0},
+		{"roll call", true, 1, 1, 1},
+	}
+	for _, tt := range tests {
+		cfg.BrowsePinned = tt.pinned
+		s, err := parseSearch(tt.query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if at, matches := s.Position(tt.id); at != tt.at || matches != tt.matches {
+			t.Errorf("%q with BrowsePinned %v: #%d is match %d of %d, want %d of %d",
+				tt.query, tt.pinned, tt.id, at, matches, tt.at, tt.matches)
+		}
+	}
+}
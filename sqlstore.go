@@ -0,0 +1,510 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"time"
+
+	_ "modernc.org/sqlite" // pure Go, so the door still builds without cgo
+)
+
+// SQLStore keeps the wall in a SQLite database. The database runs in WAL
+// mode, so callers on other nodes can read while one of them posts, and
+// writers wait their turn for up to busyTimeout instead of failing.
+type SQLStore struct {
+	db *sql.DB
+}
+
+const busyTimeout = 5 * time.Second
+
+// migrations bring the schema up to date, one step per schema version. The
+// database's user_version says how many have been run. Add new steps to the
+// end; never change one that has shipped.
+var migrations = []string{
+	// 1: messages and votes
+	`CREATE TABLE messages (
+		id        INTEGER PRIMARY KEY AUTOINCREMENT, -- never reuse a deleted post's id
+		body      TEXT NOT NULL,
+		author    TEXT NOT NULL,
+		anonymous INTEGER NOT NULL DEFAULT 0,
+		posted    TEXT NOT NULL,
+		flush     INTEGER NOT NULL DEFAULT 0,
+		keep      INTEGER NOT NULL DEFAULT 0,
+		hidden    INTEGER NOT NULL DEFAULT 0,
+		parent    INTEGER NOT NULL DEFAULT 0
+	);
+	CREATE INDEX messages_parent ON messages (parent, hidden);
+	CREATE TABLE votes (
+		seq        INTEGER PRIMARY KEY,
+		message_id INTEGER NOT NULL,
+		alias      TEXT NOT NULL COLLATE NOCASE,
+		flush      INTEGER NOT NULL,
+		UNIQUE (message_id, alias)
+	);`,
+	// 2: where posts from other boards came from
+	`ALTER TABLE messages ADD COLUMN origin TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN origin_node TEXT NOT NULL DEFAULT '';
+	ALTER TABLE messages ADD COLUMN msgid TEXT NOT NULL DEFAULT '';
+	CREATE UNIQUE INDEX messages_msgid ON messages (msgid) WHERE msgid != '';`,
+	// 3: pinning and expiry
+	`ALTER TABLE messages ADD COLUMN pinned INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE messages ADD COLUMN expires TEXT NOT NULL DEFAULT '';`,
+	// 4: times in UTC, so they sort
+	`UPDATE messages SET posted = strftime('%Y-%m-%dT%H:%M:%SZ', posted) WHERE posted != '';
+	UPDATE messages SET expires = strftime('%Y-%m-%dT%H:%M:%SZ', expires) WHERE expires != '';`,
+	// 5: callers' profiles
+	`CREATE TABLE profiles (
+		alias     TEXT NOT NULL COLLATE NOCASE,
+		user_num  INTEGER NOT NULL,
+		anonymous INTEGER NOT NULL DEFAULT 0,
+		animation INTEGER NOT NULL DEFAULT 1,
+		theme     TEXT NOT NULL DEFAULT '',
+		last_read INTEGER NOT NULL DEFAULT 0,
+		posts     INTEGER NOT NULL DEFAULT 0,
+		last_on   TEXT NOT NULL DEFAULT '',
+		PRIMARY KEY (alias, user_num)
+	);`,
+}
+
+// messageColumns are the columns scanMessage reads, in order. The reply
+// count is worked out rather than stored.
+const messageColumns = `id, body, author, anonymous, posted, flush, keep, hidden, parent,
+	origin, origin_node, msgid, pinned, expires, (SELECT COUNT(*) FROM messages r WHERE r.parent = messages.id AND r.hidden = 0)`
+
+// openSQLStore opens the database at path, creating it if need be, and
+// migrates it to the current schema.
+func openSQLStore(path string) (*SQLStore, error) {
+	q := url.Values{}
+	q.Add("_pragma", fmt.Sprintf("busy_timeout(%d)", busyTimeout.Milliseconds()))
+	q.Add("_pragma", "journal_mode(WAL)")
+	q.Add("_pragma", "synchronous(NORMAL)")
+	q.Set("_txlock", "immediate") // take the write lock up front, so votes can't race
+	db, err := sql.Open("sqlite", "file:"+path+"?"+q.Encode())
+	if err != nil {
+		return nil, err
+	}
+	s := &SQLStore{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return s, nil
+}
+
+// migrate runs the migrations the database hasn't had yet.
+func (s *SQLStore) migrate() error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var version int
+	if err := tx.QueryRow(`PRAGMA user_version`).Scan(&version); err != nil {
+		return err
+	}
+	if version > len(migrations) {
+		return fmt.Errorf("schema version %d is newer than this door knows (%d)", version, len(migrations))
+	}
+	for i := version; i < len(migrations); i++ {
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			return fmt.Errorf("migrating to schema version %d: %v", i+1, err)
+		}
+	}
+	if _, err := tx.Exec(fmt.Sprintf(`PRAGMA user_version = %d`, len(migrations))); err != nil {
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *SQLStore) Close() error {
+	return s.db.Close()
+}
+
+// Messages returns every message, hidden ones included, oldest first.
+func (s *SQLStore) Messages() ([]Message, error) {
+	return s.query(`SELECT ` + messageColumns + ` FROM messages ORDER BY id`)
+}
+
+// Get returns message id. ok is false if there is no such message.
+func (s *SQLStore) Get(id int) (Message, bool, error) {
+	m, err := scanMessage(s.db.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Message{}, false, nil
+	}
+	if err != nil {
+		return Message{}, false, err
+	}
+	return m, true, nil
+}
+
+// Seek returns the first visible post after id from, or before it when dir
+// is negative, that match accepts, or nil when there isn't one.
+func (s *SQLStore) Seek(from, dir int, match func(*Message) bool) (*Message, error) {
+	query := `SELECT ` + messageColumns + ` FROM messages
+		WHERE parent = 0 AND hidden = 0 AND id > ? ORDER BY id`
+	if dir < 0 {
+		query = `SELECT ` + messageColumns + ` FROM messages
+		WHERE parent = 0 AND hidden = 0 AND id < ? ORDER BY id DESC`
+	}
+	rows, err := s.db.Query(query, from)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		if match == nil || match(&m) {
+			return &m, nil
+		}
+	}
+	return nil, rows.Err()
+}
+
+// Find returns the ids of the visible posts after id from that match
+// accepts, oldest first.
+func (s *SQLStore) Find(from int, match func(*Message) bool) ([]int, error) {
+	if match == nil {
+		rows, err := s.db.Query(`SELECT id FROM messages WHERE parent = 0 AND hidden = 0 AND id > ? ORDER BY id`, from)
+		if err != nil {
+			return nil, err
+		}
+		defer rows.Close()
+
+		var ids []int
+		for rows.Next() {
+			var id int
+			if err := rows.Scan(&id); err != nil {
+				return nil, err
+			}
+			ids = append(ids, id)
+		}
+		return ids, rows.Err()
+	}
+
+	msgs, err := s.query(`SELECT `+messageColumns+` FROM messages
+		WHERE parent = 0 AND hidden = 0 AND id > ? ORDER BY id`, from)
+	if err != nil {
+		return nil, err
+	}
+	var ids []int
+	for i := range msgs {
+		if match(&msgs[i]) {
+			ids = append(ids, msgs[i].ID)
+		}
+	}
+	return ids, nil
+}
+
+// Pinned returns the visible pinned posts, newest first.
+func (s *SQLStore) Pinned() ([]Message, error) {
+	return s.query(`SELECT ` + messageColumns + ` FROM messages
+		WHERE parent = 0 AND hidden = 0 AND pinned = 1 ORDER BY id DESC`)
+}
+
+// Replies returns the visible replies to message id, oldest first.
+func (s *SQLStore) Replies(id int) ([]Message, error) {
+	return s.query(`SELECT `+messageColumns+` FROM messages
+		WHERE parent = ? AND hidden = 0 ORDER BY id`, id)
+}
+
+// query returns the messages a SELECT of messageColumns finds.
+func (s *SQLStore) query(query string, args ...interface{}) ([]Message, error) {
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var msgs []Message
+	for rows.Next() {
+		m, err := scanMessage(rows)
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, m)
+	}
+	return msgs, rows.Err()
+}
+
+// Post adds a message to the wall, giving it the next id.
+func (s *SQLStore) Post(m *Message) error {
+	res, err := s.db.Exec(`INSERT INTO messages (body, author, anonymous, posted, flush, keep, hidden, parent,
+		origin, origin_node, msgid, pinned, expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.Body, m.Author, m.Anonymous, storeTime(m.Posted), m.Flush, m.Keep, m.Hidden, m.Parent,
+		m.Origin, m.OriginNode, m.MsgID, m.Pinned, sqlTime(m.Expires))
+	if err != nil {
+		return err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+	m.ID = int(id)
+	return nil
+}
+
+// Vote records alias's vote to flush or keep message id and returns the
+// message with its new tallies. Once flushVotes flush votes outnumber the
+// keeps the message is hidden; zero turns that off.
+func (s *SQLStore) Vote(id int, alias string, flush bool, flushVotes int) (Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Message{}, fmt.Errorf("no message %d", id)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+
+	res, err := tx.Exec(`INSERT OR IGNORE INTO votes (message_id, alias, flush) VALUES (?, ?, ?)`, id, alias, flush)
+	if err != nil {
+		return Message{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Message{}, err
+	} else if n == 0 {
+		return Message{}, errAlreadyVoted
+	}
+
+	if flush {
+		m.Flush++
+	} else {
+		m.Keep++
+	}
+	if flushVotes > 0 && m.Flush >= flushVotes && m.Flush > m.Keep {
+		m.Hidden = true
+	}
+	if _, err := tx.Exec(`UPDATE messages SET flush = ?, keep = ?, hidden = ? WHERE id = ?`,
+		m.Flush, m.Keep, m.Hidden, id); err != nil {
+		return Message{}, err
+	}
+	return m, tx.Commit()
+}
+
+// Change changes message id with change and saves it, in a transaction
+// that holds the write lock, so nodes take turns.
+func (s *SQLStore) Change(id int, change func(*Message)) (Message, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Message{}, err
+	}
+	defer tx.Rollback()
+
+	m, err := scanMessage(tx.QueryRow(`SELECT `+messageColumns+` FROM messages WHERE id = ?`, id))
+	if err == sql.ErrNoRows {
+		return Message{}, fmt.Errorf("no message %d", id)
+	}
+	if err != nil {
+		return Message{}, err
+	}
+	change(&m)
+	m.ID = id
+	if _, err := tx.Exec(`UPDATE messages SET body = ?, author = ?, anonymous = ?, posted = ?, flush = ?, keep = ?,
+		hidden = ?, parent = ?, origin = ?, origin_node = ?, msgid = ?, pinned = ?, expires = ? WHERE id = ?`,
+		m.Body, m.Author, m.Anonymous, storeTime(m.Posted), m.Flush, m.Keep, m.Hidden, m.Parent,
+		m.Origin, m.OriginNode, m.MsgID, m.Pinned, sqlTime(m.Expires), id); err != nil {
+		return Message{}, err
+	}
+	return m, tx.Commit()
+}
+
+// Hide hides message id from the wall, or puts it back, and returns it.
+func (s *SQLStore) Hide(id int, hidden bool) (Message, error) {
+	res, err := s.db.Exec(`UPDATE messages SET hidden = ? WHERE id = ?`, hidden, id)
+	if err != nil {
+		return Message{}, err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return Message{}, err
+	} else if n == 0 {
+		return Message{}, fmt.Errorf("no message %d", id)
+	}
+	m, _, err := s.Get(id)
+	return m, err
+}
+
+// Delete removes message id, the replies to it and their votes, and returns
+// how many messages went.
+func (s *SQLStore) Delete(id int) (int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(`DELETE FROM votes WHERE message_id IN
+		(SELECT id FROM messages WHERE id = ? OR parent = ?)`, id, id); err != nil {
+		return 0, err
+	}
+	res, err := tx.Exec(`DELETE FROM messages WHERE id = ? OR parent = ?`, id, id)
+	if err != nil {
+		return 0, err
+	}
+	n, err := res.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+	if n == 0 {
+		return 0, fmt.Errorf("no message %d", id)
+	}
+	return int(n), tx.Commit()
+}
+
+// Compact folds the write-ahead log into the database and gives the space
+// left by deleted messages back to the filesystem.
+func (s *SQLStore) Compact() error {
+	if _, err := s.db.Exec(`PRAGMA wal_checkpoint(TRUNCATE)`); err != nil {
+		return err
+	}
+	_, err := s.db.Exec(`VACUUM`)
+	return err
+}
+
+// Votes returns every vote cast, in the order they were cast.
+func (s *SQLStore) Votes() ([]Vote, error) {
+	rows, err := s.db.Query(`SELECT message_id, alias, flush FROM votes ORDER BY seq`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var votes []Vote
+	for rows.Next() {
+		var v Vote
+		if err := rows.Scan(&v.ID, &v.Alias, &v.Flush); err != nil {
+			return nil, err
+		}
+		votes = append(votes, v)
+	}
+	return votes, rows.Err()
+}
+
+// Import adds messages and votes with the ids they already have, all in
+// one transaction.
+func (s *SQLStore) Import(msgs []Message, votes []Vote) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, m := range msgs {
+		if _, err := tx.Exec(`INSERT OR REPLACE INTO messages (id, body, author, anonymous, posted, flush, keep, hidden, parent,
+			origin, origin_node, msgid, pinned, expires) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			m.ID, m.Body, m.Author, m.Anonymous, storeTime(m.Posted), m.Flush, m.Keep, m.Hidden, m.Parent,
+			m.Origin, m.OriginNode, m.MsgID, m.Pinned, sqlTime(m.Expires)); err != nil {
+			return err
+		}
+	}
+	for _, v := range votes {
+		if _, err := tx.Exec(`INSERT OR IGNORE INTO votes (message_id, alias, flush) VALUES (?, ?, ?)`,
+			v.ID, v.Alias, v.Flush); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// profileColumns are the columns scanProfile reads, in order.
+const profileColumns = `alias, user_num, anonymous, animation, theme, last_read, posts, last_on`
+
+// Profile returns a caller's profile, or a new one if they haven't got one.
+func (s *SQLStore) Profile(alias string, userNum int) (Profile, error) {
+	p, err := scanProfile(s.db.QueryRow(`SELECT `+profileColumns+` FROM profiles WHERE alias = ? AND user_num = ?`,
+		alias, userNum))
+	if err == sql.ErrNoRows {
+		return newProfile(alias, userNum), nil
+	}
+	return p, err
+}
+
+// UpdateProfile changes a caller's profile with change and saves it, in a
+// transaction that holds the write lock, so nodes take turns.
+func (s *SQLStore) UpdateProfile(alias string, userNum int, change func(*Profile)) (Profile, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return Profile{}, err
+	}
+	defer tx.Rollback()
+
+	p, err := scanProfile(tx.QueryRow(`SELECT `+profileColumns+` FROM profiles WHERE alias = ? AND user_num = ?`,
+		alias, userNum))
+	if err == sql.ErrNoRows {
+		p, err = newProfile(alias, userNum), nil
+	}
+	if err != nil {
+		return Profile{}, err
+	}
+	change(&p)
+	if _, err := tx.Exec(`INSERT OR REPLACE INTO profiles (`+profileColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+		p.Alias, p.UserNum, p.Anonymous, p.Animation, p.Theme, p.LastRead, p.Posts, sqlTime(p.LastOn)); err != nil {
+		return Profile{}, err
+	}
+	return p, tx.Commit()
+}
+
+// Profiles returns every caller's profile.
+func (s *SQLStore) Profiles() ([]Profile, error) {
+	rows, err := s.db.Query(`SELECT ` + profileColumns + ` FROM profiles ORDER BY alias, user_num`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var profiles []Profile
+	for rows.Next() {
+		p, err := scanProfile(rows)
+		if err != nil {
+			return nil, err
+		}
+		profiles = append(profiles, p)
+	}
+	return profiles, rows.Err()
+}
+
+func scanProfile(row rowScanner) (Profile, error) {
+	var p Profile
+	var lastOn string
+	if err := row.Scan(&p.Alias, &p.UserNum, &p.Anonymous, &p.Animation, &p.Theme, &p.LastRead, &p.Posts,
+		&lastOn); err != nil {
+		return Profile{}, err
+	}
+	p.LastOn = parseStoredTime(lastOn)
+	return p, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanMessage(row rowScanner) (Message, error) {
+	var m Message
+	var posted, expires string
+	if err := row.Scan(&m.ID, &m.Body, &m.Author, &m.Anonymous, &posted, &m.Flush, &m.Keep, &m.Hidden,
+		&m.Parent, &m.Origin, &m.OriginNode, &m.MsgID, &m.Pinned, &expires, &m.Replies); err != nil {
+		return Message{}, err
+	}
+	m.Posted = parseStoredTime(posted)
+	m.Expires = parseStoredTime(expires)
+	return m, nil
+}
+
+// sqlTime stores a time that may be unset, as "" when it is.
+func sqlTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return storeTime(t)
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Store is where the wall is kept. FlatStore keeps it in the door's text
+// file and SQLStore in a SQLite database; the Store keyword in the config
+// file picks one.
+type Store interface {
+	// Messages returns every message, hidden ones included, oldest first.
+	Messages() ([]Message, error)
+	// Get returns message id with its reply count. ok is false if there is
+	// no such message.
+	Get(id int) (m Message, ok bool, err error)
+	// Seek returns the first visible post after id from, or before it when
+	// dir is negative, that match accepts, or nil when there isn't one.
+	Seek(from, dir int, match func(*Message) bool) (*Message, error)
+	// Find returns the ids of the visible posts after id from that match
+	// accepts, oldest first, looking through the store once.
+	Find(from int, match func(*Message) bool) ([]int, error)
+	// Pinned returns the visible pinned posts, newest first.
+	Pinned() ([]Message, error)
+	// Replies returns the visible replies to message id, oldest first.
+	Replies(id int) ([]Message, error)
+	// Post adds a message to the wall, giving it the next id.
+	Post(m *Message) error
+	// Vote records alias's vote on message id and returns the message with
+	// its new tallies; see FlatStore.Vote.
+	Vote(id int, alias string, flush bool, flushVotes int) (Message, error)
+	// Change changes message id with change and saves it, one node at a
+	// time, and returns it as saved.
+	Change(id int, change func(*Message)) (Message, error)
+	// Hide hides message id from the wall, or puts it back, and returns it.
+	Hide(id int, hidden bool) (Message, error)
+	// Delete removes message id and the replies to it, and returns how
+	// many messages went.
+	Delete(id int) (int, error)
+	// Compact gives back the space taken by changed and deleted messages.
+	Compact() error
+	// Votes returns every vote cast, in the order they were cast.
+	Votes() ([]Vote, error)
+	// Import adds messages and votes as they are, ids included. It is for
+	// filling an empty store.
+	Import(msgs []Message, votes []Vote) error
+	// Profile returns a caller's profile, or a new one if they haven't got
+	// one; see profile.go.
+	Profile(alias string, userNum int) (Profile, error)
+	// UpdateProfile changes a caller's profile with change and saves it,
+	// one node at a time, and returns it as saved.
+	UpdateProfile(alias string, userNum int, change func(*Profile)) (Profile, error)
+	// Profiles returns every caller's profile.
+	Profiles() ([]Profile, error)
+	Close() error
+}
+
+// Vote is one alias's vote on a message.
+type Vote struct {
+	ID    int    `json:"id"` // the message voted on
+	Alias string `json:"alias"`
+	Flush bool   `json:"flush"` // false for a vote to keep it
+}
+
+var store Store = &FlatStore{Path: "messages.txt"}
+
+// openStore opens the store named by a Store config line: flat or sqlite,
+// and the file to keep it in.
+func openStore(backend, path string) (Store, error) {
+	switch strings.ToLower(backend) {
+	case "flat":
+		return &FlatStore{Path: path}, nil
+	case "sqlite":
+		return openSQLStore(path)
+	}
+	return nil, fmt.Errorf("unknown store %q, want flat or sqlite", backend)
+}
+
+// parseStoreSpec reads a store given on the command line as backend:path.
+func parseStoreSpec(spec string) (backend, path string, err error) {
+	backend, path, found := strings.Cut(spec, ":")
+	if !found || path == "" {
+		return "", "", fmt.Errorf("%q should be flat:<file> or sqlite:<file>", spec)
+	}
+	return backend, path, nil
+}
+
+// storeCommand handles "toilet-redux store migrate <from> <to>", which
+// copies every message, vote and profile from one store into another,
+// empty, one.
+func storeCommand(args []string) int {
+	if len(args) != 3 || args[0] != "migrate" {
+		fmt.Fprintln(os.Stderr, "usage: toilet-redux store migrate <backend>:<file> <backend>:<file>")
+		fmt.Fprintln(os.Stderr, "e.g.:  toilet-redux store migrate flat:messages.txt sqlite:messages.db")
+		return 2
+	}
+	if err := migrateStore(args[1], args[2]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 1
+	}
+	return 0
+}
+
+func migrateStore(fromSpec, toSpec string) error {
+	from, err := openStoreSpec(fromSpec)
+	if err != nil {
+		return err
+	}
+	defer from.Close()
+	to, err := openStoreSpec(toSpec)
+	if err != nil {
+		return err
+	}
+	defer to.Close()
+
+	existing, err := to.Messages()
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return fmt.Errorf("%s already has %d messages; migrate into an empty store", toSpec, len(existing))
+	}
+
+	msgs, err := from.Messages()
+	if err != nil {
+		return err
+	}
+	votes, err := from.Votes()
+	if err != nil {
+		return err
+	}
+	if err := to.Import(msgs, votes); err != nil {
+		return err
+	}
+	profiles, err := from.Profiles()
+	if err != nil {
+		return err
+	}
+	for _, p := range profiles {
+		p := p
+		if _, err := to.UpdateProfile(p.Alias, p.UserNum, func(q *Profile) { *q = p }); err != nil {
+			return err
+		}
+	}
+	fmt.Printf("copied %d messages, %d votes and %d profiles from %s to %s\n",
+		len(msgs), len(votes), len(profiles), fromSpec, toSpec)
+	return nil
+}
+
+func openStoreSpec(spec string) (Store, error) {
+	backend, path, err := parseStoreSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+	return openStore(backend, path)
+}
@@ -0,0 +1,178 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// The message box is filled by a text/template, so a theme can change how
+// posts are laid out without a new build of the door. The template writes
+// the box a line at a time; blank lines leave the art showing, and lines
+// past the bottom of the box are dropped.
+// A theme picks its template with a Template line in its manifest, and
+// themes without one get defaultMessageTemplate.
+
+// defaultMessageTemplate is the classic look: wrapped, each line centered,
+// and the whole centered top to bottom, with "..." when it runs out of room.
+const defaultMessageTemplate = `{{range middle .Height (clip .Height .Width (wrap .Width .Text)) -}}
+{{if .}}{{center $.Width .}}{{end}}
+{{end -}}`
+
+// messageView is what a message template is given.
+type messageView struct {
+	ID      int
+	Body    string // the post as typed, pipe codes and all
+	Text    string // the post with its pipe codes turned into colors, ready to wrap
+	Author  string // the byline, "Anonymous" for anonymous posts
+	Date    string // when it was posted, the way the board shows dates
+	Posted  time.Time
+	Keep    int
+	Flush   int
+	Replies int
+	Reply   bool // a reply rather than a post on the wall
+	Pinned  bool
+	Color   string // the box's color, to go back to after changing it
+	X, Y    int    // where the box is on the screen
+	Width   int
+	Height  int
+}
+
+// messageFuncs are the functions message templates can call. Lines are
+// strings that may hold colors; widths count what shows on screen.
+var messageFuncs = template.FuncMap{
+	"wrap":   wrapLines,
+	"clip":   clipLines,
+	"middle": middleLines,
+	"center": func(width int, text string) string { return layout.Center(text, width) },
+	"left":   leftText,
+	"right":  rightText,
+	"jitter": jitterText,
+	"color":  templateColor,
+	"add":    func(a, b int) int { return a + b },
+}
+
+var defaultTemplate = template.Must(template.New("message").Funcs(messageFuncs).Parse(defaultMessageTemplate))
+
+// loadMessageTemplate parses a theme's message template.
+func loadMessageTemplate(path string) (*template.Template, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return template.New(filepath.Base(path)).Funcs(messageFuncs).Parse(string(data))
+}
+
+// formatMessage lays m out for a width by height message box in color,
+// using the theme's template. A template that fails falls back to the
+// default one, so a broken theme still shows the wall.
+func formatMessage(m *Message, color string, width, height int) []string {
+	body := m.Body
+	if cfg.StripColors {
+		body = stripMarkup(body)
+	}
+	box := theme.Region("message")
+	view := messageView{
+		ID: m.ID, Body: m.Body, Text: renderMarkup(body, color),
+		Author: m.Byline(), Date: showTime(m.Posted, time.Now()), Posted: m.Posted,
+		Keep: m.Keep, Flush: m.Flush, Replies: m.Replies, Reply: m.Parent != 0, Pinned: m.Pinned,
+		Color: color, X: box.X, Y: box.Y, Width: width, Height: height,
+	}
+	tmpl := theme.Template
+	if tmpl == nil {
+		tmpl = defaultTemplate
+	}
+	lines, err := runMessageTemplate(tmpl, &view)
+	if err != nil {
+		lines, _ = runMessageTemplate(defaultTemplate, &view)
+	}
+	if len(lines) > height {
+		lines = lines[:height]
+	}
+	return lines
+}
+
+func runMessageTemplate(tmpl *template.Template, view *messageView) ([]string, error) {
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, view); err != nil {
+		return nil, err
+	}
+	text := strings.TrimSuffix(out.String(), "\n")
+	if text == "" {
+		return nil, nil
+	}
+	return strings.Split(text, "\n"), nil
+}
+
+// wrapLines word-wraps colored text to width, starting each line in the
+// color the last one ended in.
+func wrapLines(width int, text string) []string {
+	return carryColors(layout.Wrap(text, width))
+}
+
+// clipLines keeps the first n lines, ending the last with "..." when some
+// had to go.
+func clipLines(n, width int, lines []string) []string {
+	if len(lines) <= n || n < 1 {
+		return lines
+	}
+	lines = append([]string(nil), lines[:n]...)
+	last := strings.TrimRight(lines[n-1], " ")
+	lines[n-1] = layout.Truncate(last+"...", width, "...")
+	return lines
+}
+
+// middleLines pads lines with blank ones above and below to fill height.
+func middleLines(height int, lines []string) []string {
+	if len(lines) >= height {
+		return lines
+	}
+	top := (height - len(lines)) / 2
+	padded := make([]string, 0, height)
+	for i := 0; i < top; i++ {
+		padded = append(padded, "")
+	}
+	padded = append(padded, lines...)
+	for len(padded) < height {
+		padded = append(padded, "")
+	}
+	return padded
+}
+
+// leftText pads text on the right to width, cutting it if it's too long.
+func leftText(width int, text string) string {
+	return layout.PadRight(text, width)
+}
+
+// rightText pads text on the left to width.
+func rightText(width int, text string) string {
+	return layout.PadLeft(text, width)
+}
+
+// jitterText puts text at a random-looking spot across width, the same
+// spot every time for the same seed, so lines look scrawled by hand.
+func jitterText(seed, width int, text string) string {
+	room := width - layout.Width(text)
+	if room <= 0 {
+		return layout.PadRight(text, width)
+	}
+	h := fnv.New32a()
+	fmt.Fprint(h, seed)
+	return layout.Place(text, width, int(h.Sum32()%uint32(room+1)))
+}
+
+// templateColor is the SGR for a theme color name, like "author", or for a
+// color spec, like "yellow+ on blue".
+func templateColor(name string) (string, error) {
+	if c, ok := theme.Colors[strings.ToLower(name)]; ok {
+		return c, nil
+	}
+	return parseColor(name)
+}
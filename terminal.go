@@ -0,0 +1,466 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"regexp"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/eiannone/keyboard"
+)
+
+// escapeWait is how long to wait for the rest of an escape sequence before
+// deciding the caller just pressed Esc.
+const escapeWait = 50 * time.Millisecond
+
+var (
+	errTimeout = errors.New("terminal: timed out waiting for input")
+	errResized = errors.New("terminal: resized")
+)
+
+// Telnet protocol bytes
+const (
+	telnetSE   = 240
+	telnetSB   = 250
+	telnetWill = 251
+	telnetWont = 252
+	telnetDo   = 253
+	telnetDont = 254
+	telnetIAC  = 255
+	telnetNAWS = 31
+)
+
+// Terminal is the caller's side of the session. Writes are UTF-8 and get
+// converted to the session charset; keys read back are normalized to UTF-8
+// runes so messages are always stored the same way.
+type Terminal struct {
+	Charset Charset
+
+	// Telnet is set when the door talks straight to the caller's telnet
+	// socket: IAC commands are stripped from input and NAWS reports resize
+	// the screen.
+	Telnet bool
+
+	// OnResize is called from GetKey, on the reading goroutine, when the
+	// caller's screen size changes.
+	OnResize func(w, h int)
+
+	w       io.Writer
+	r       io.Reader
+	partial []byte // incomplete UTF-8 sequence left over from the last Write
+
+	start   sync.Once
+	chunks  chan []byte
+	readErr error
+	buf     []byte // unread input, telnet commands already removed
+
+	resizeMu sync.Mutex // held by whoever is replacing the size in resized
+	resized  chan [2]int
+
+	tnState int
+	tnSub   []byte
+	naws    [2]int
+}
+
+// Telnet input parser states
+const (
+	tnData = iota
+	tnIAC
+	tnOption
+	tnSub
+	tnSubIAC
+)
+
+var term = NewTerminal(os.Stdin, os.Stdout, CharsetUTF8)
+
+// NewTerminal creates a Terminal reading keys from r and writing to w.
+func NewTerminal(r io.Reader, w io.Writer, cs Charset) *Terminal {
+	return &Terminal{
+		Charset: cs,
+		w:       w,
+		r:       r,
+		chunks:  make(chan []byte, 16),
+		resized: make(chan [2]int, 1),
+	}
+}
+
+// Write converts UTF-8 text to the session charset and sends it.
+func (t *Terminal) Write(p []byte) (int, error) {
+	if t.Charset == CharsetUTF8 {
+		return t.w.Write(p)
+	}
+
+	data := p
+	if len(t.partial) > 0 {
+		data = append(t.partial, p...)
+		t.partial = nil
+	}
+
+	out := make([]byte, 0, len(data))
+	for len(data) > 0 {
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && !utf8.FullRune(data) {
+			t.partial = append([]byte(nil), data...) // wait for the rest of the rune
+			break
+		}
+		out = append(out, t.Charset.encodeRune(r))
+		data = data[size:]
+	}
+
+	if _, err := t.WriteRaw(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// WriteRaw sends bytes as they are, without converting them to the session
+// charset, for control codes whose bytes aren't text.
+func (t *Terminal) WriteRaw(p []byte) (int, error) {
+	if !t.Telnet || bytes.IndexByte(p, telnetIAC) < 0 {
+		return t.w.Write(p)
+	}
+	out := make([]byte, 0, len(p)+1)
+	for _, b := range p {
+		out = append(out, b)
+		if b == telnetIAC {
+			out = append(out, telnetIAC) // a literal 0xff must be doubled
+		}
+	}
+	if _, err := t.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Resized reports a new screen size, for example from SIGWINCH. The
+// OnResize handler runs the next time GetKey is waiting for a key.
+func (t *Terminal) Resized(w, h int) {
+	t.resizeMu.Lock()
+	defer t.resizeMu.Unlock()
+	select {
+	case <-t.resized: // drop a size that was never handled
+	default:
+	}
+	t.resized <- [2]int{w, h} // nobody else can fill the slot meanwhile
+}
+
+// GetKey reads one key from the caller. Like keyboard.GetKey, control keys
+// come back as a Key with a zero rune and printable input as a rune.
+func (t *Terminal) GetKey() (rune, keyboard.Key, error) {
+	for {
+		for len(t.buf) == 0 {
+			if err := t.fill(0, true); err != nil && err != errResized {
+				return 0, 0, err
+			}
+		}
+		b := t.next()
+
+		switch {
+		case b == 0x1b:
+			if key, ok := t.readEscape(); ok {
+				return 0, key, nil
+			}
+		case b == '\r' || b == '\n':
+			t.skipLineEnd(b)
+			return 0, keyboard.KeyEnter, nil
+		case b <= 0x20 || b == 0x7f:
+			return 0, keyboard.Key(b), nil
+		case b < 0x80:
+			return rune(b), 0, nil
+		case t.Charset == CharsetUTF8:
+			if r, ok := t.readRune(b); ok {
+				return r, 0, nil
+			}
+		default:
+			return t.Charset.decodeByte(b), 0, nil
+		}
+	}
+}
+
+// Query sends a request to the terminal and waits up to timeout for a reply
+// matching re, returning its submatches. Anything else that arrives in the
+// meantime, like keys the caller typed, is kept for GetKey.
+func (t *Terminal) Query(request string, re *regexp.Regexp, timeout time.Duration) ([]string, bool) {
+	io.WriteString(t, request)
+
+	var got []byte
+	deadline := time.Now().Add(timeout)
+	for {
+		for len(t.buf) > 0 {
+			got = append(got, t.next())
+			if loc := re.FindSubmatchIndex(got); loc != nil {
+				var match []string
+				for i := 0; i < len(loc); i += 2 {
+					if loc[i] >= 0 {
+						match = append(match, string(got[loc[i]:loc[i+1]]))
+					} else {
+						match = append(match, "")
+					}
+				}
+				t.unread(append(got[:loc[0]:loc[0]], got[loc[1]:]...))
+				return match, true
+			}
+		}
+
+		remaining := time.Until(deadline)
+		if remaining <= 0 || t.fill(remaining, false) != nil {
+			t.unread(got)
+			return nil, false
+		}
+	}
+}
+
+// RequestNAWS asks a telnet client to report its window size and waits up
+// to timeout for the answer.
+func (t *Terminal) RequestNAWS(timeout time.Duration) (int, int, bool) {
+	if !t.Telnet {
+		return 0, 0, false
+	}
+	t.w.Write([]byte{telnetIAC, telnetDo, telnetNAWS})
+
+	deadline := time.Now().Add(timeout)
+	for t.naws[0] == 0 || t.naws[1] == 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 || t.fill(remaining, false) != nil {
+			return 0, 0, false
+		}
+	}
+	return t.naws[0], t.naws[1], true
+}
+
+// fill waits for the next chunk of input, for up to timeout, or forever
+// when timeout is zero. When resizable is set a screen size change also
+// ends the wait, after OnResize has run.
+func (t *Terminal) fill(timeout time.Duration, resizable bool) error {
+	t.start.Do(func() { go t.pump() })
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+	var resized <-chan [2]int
+	if resizable {
+		resized = t.resized
+	}
+
+	select {
+	case chunk, ok := <-t.chunks:
+		if !ok {
+			return t.readErr
+		}
+		t.intake(chunk)
+		return nil
+	case size := <-resized:
+		if t.OnResize != nil {
+			t.OnResize(size[0], size[1])
+		}
+		return errResized
+	case <-expired:
+		return errTimeout
+	}
+}
+
+// pump copies input from the reader into the chunk channel, so reads can
+// give up after a deadline without losing what arrives later.
+func (t *Terminal) pump() {
+	buf := make([]byte, 256)
+	for {
+		n, err := t.r.Read(buf)
+		if n > 0 {
+			t.chunks <- append([]byte(nil), buf[:n]...)
+		}
+		if err != nil {
+			t.readErr = err
+			close(t.chunks)
+			return
+		}
+	}
+}
+
+// intake adds a chunk of input to the buffer, acting on and removing telnet
+// commands when the session is a raw telnet stream.
+func (t *Terminal) intake(chunk []byte) {
+	if !t.Telnet {
+		t.buf = append(t.buf, chunk...)
+		return
+	}
+
+	for _, b := range chunk {
+		switch t.tnState {
+		case tnData:
+			if b == telnetIAC {
+				t.tnState = tnIAC
+			} else {
+				t.buf = append(t.buf, b)
+			}
+		case tnIAC:
+			switch b {
+			case telnetIAC:
+				t.buf = append(t.buf, b)
+				t.tnState = tnData
+			case telnetWill, telnetWont, telnetDo, telnetDont:
+				t.tnState = tnOption
+			case telnetSB:
+				t.tnSub = t.tnSub[:0]
+				t.tnState = tnSub
+			default:
+				t.tnState = tnData
+			}
+		case tnOption:
+			t.tnState = tnData
+		case tnSub:
+			if b == telnetIAC {
+				t.tnState = tnSubIAC
+			} else {
+				t.tnSub = append(t.tnSub, b)
+			}
+		case tnSubIAC:
+			if b == telnetSE {
+				t.subnegotiation(t.tnSub)
+				t.tnState = tnData
+			} else {
+				t.tnSub = append(t.tnSub, b)
+				t.tnState = tnSub
+			}
+		}
+	}
+}
+
+// subnegotiation handles a telnet SB block. Only NAWS matters to us.
+func (t *Terminal) subnegotiation(sub []byte) {
+	if len(sub) < 5 || sub[0] != telnetNAWS {
+		return
+	}
+	w := int(sub[1])<<8 | int(sub[2])
+	h := int(sub[3])<<8 | int(sub[4])
+	if w == 0 || h == 0 {
+		return
+	}
+	first := t.naws[0] == 0
+	t.naws = [2]int{w, h}
+	if !first {
+		t.Resized(w, h)
+	}
+}
+
+// next removes and returns the first buffered byte.
+func (t *Terminal) next() byte {
+	b := t.buf[0]
+	t.buf = t.buf[1:]
+	return b
+}
+
+// unread puts bytes back in front of the buffer.
+func (t *Terminal) unread(b []byte) {
+	if len(b) > 0 {
+		t.buf = append(append([]byte(nil), b...), t.buf...)
+	}
+}
+
+// readByte returns the next byte, waiting up to timeout for it.
+func (t *Terminal) readByte(timeout time.Duration) (byte, error) {
+	deadline := time.Now().Add(timeout)
+	for len(t.buf) == 0 {
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return 0, errTimeout
+		}
+		if err := t.fill(remaining, false); err != nil {
+			return 0, err
+		}
+	}
+	return t.next(), nil
+}
+
+// readEscape interprets what follows an ESC. A lone ESC is the Esc key;
+// cursor key sequences map to their keyboard keys. Sequences we don't know
+// are swallowed and reported as not ok.
+func (t *Terminal) readEscape() (keyboard.Key, bool) {
+	b, err := t.readByte(escapeWait)
+	if err != nil {
+		return keyboard.KeyEsc, true
+	}
+	if b != '[' && b != 'O' {
+		t.unread([]byte{b})
+		return keyboard.KeyEsc, true
+	}
+
+	var params []byte
+	for {
+		b, err := t.readByte(escapeWait)
+		if err != nil {
+			return 0, false
+		}
+		if b >= 0x40 && b <= 0x7e {
+			return escapeKey(b, string(params))
+		}
+		params = append(params, b)
+	}
+}
+
+// escapeKey maps the final byte and parameters of a CSI or SS3 sequence to a
+// key.
+func escapeKey(final byte, params string) (keyboard.Key, bool) {
+	switch final {
+	case 'A':
+		return keyboard.KeyArrowUp, true
+	case 'B':
+		return keyboard.KeyArrowDown, true
+	case 'C':
+		return keyboard.KeyArrowRight, true
+	case 'D':
+		return keyboard.KeyArrowLeft, true
+	case 'H':
+		return keyboard.KeyHome, true
+	case 'F', 'K':
+		return keyboard.KeyEnd, true
+	case '~':
+		switch params {
+		case "1", "7":
+			return keyboard.KeyHome, true
+		case "2":
+			return keyboard.KeyInsert, true
+		case "3":
+			return keyboard.KeyDelete, true
+		case "4", "8":
+			return keyboard.KeyEnd, true
+		case "5":
+			return keyboard.KeyPgup, true
+		case "6":
+			return keyboard.KeyPgdn, true
+		}
+	}
+	return 0, false
+}
+
+// skipLineEnd drops the LF or NUL that telnet clients send after a CR, so
+// one press of Enter is one key.
+func (t *Terminal) skipLineEnd(first byte) {
+	if first != '\r' {
+		return
+	}
+	if b, err := t.readByte(escapeWait); err == nil && b != '\n' && b != 0 {
+		t.unread([]byte{b})
+	}
+}
+
+// readRune collects the rest of a multi-byte UTF-8 sequence that starts with
+// lead. Invalid sequences are dropped.
+func (t *Terminal) readRune(lead byte) (rune, bool) {
+	buf := []byte{lead}
+	for !utf8.FullRune(buf) {
+		b, err := t.readByte(escapeWait)
+		if err != nil {
+			return 0, false
+		}
+		buf = append(buf, b)
+	}
+	r, _ := utf8.DecodeRune(buf)
+	return r, r != utf8.RuneError
+}
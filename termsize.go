@@ -0,0 +1,54 @@
+package main
+
+import (
+	"regexp"
+	"strconv"
+	"time"
+)
+
+// cursorReport matches a DSR cursor position reply: ESC [ row ; col R
+var cursorReport = regexp.MustCompile(`\x1b\[(\d+);(\d+)R`)
+
+// Size sources, in the order detectTermSize tries them
+const (
+	sizeFromNAWS    = "telnet NAWS"
+	sizeFromTTY     = "tty window size"
+	sizeFromDSR     = "cursor position report"
+	sizeFromDefault = "config default"
+)
+
+// detectTermSize finds the caller's screen size. It asks a telnet client
+// for NAWS, then the tty for its window size, then parks the cursor in the
+// far corner and asks the terminal where it ended up, if it speaks ANSI.
+// Each probe gives up after the configured timeout, and when none of them
+// answer the config's default size is used.
+func detectTermSize(t *Terminal, fd int, cfg Config, ansi bool) (w, h int, source string) {
+	if w, h, ok := t.RequestNAWS(cfg.ProbeTimeout); ok {
+		return w, h, sizeFromNAWS
+	}
+	if w, h, ok := ttySize(fd); ok {
+		return w, h, sizeFromTTY
+	}
+	if !ansi {
+		return cfg.ScreenWidth, cfg.ScreenHeight, sizeFromDefault
+	}
+	if w, h, ok := querySize(t, cfg.ProbeTimeout); ok {
+		return w, h, sizeFromDSR
+	}
+	return cfg.ScreenWidth, cfg.ScreenHeight, sizeFromDefault
+}
+
+// querySize moves the cursor further than any screen goes and reads back
+// where the terminal clamped it to.
+func querySize(t *Terminal, timeout time.Duration) (int, int, bool) {
+	reply, ok := t.Query(Esc+"s"+Esc+"999;999H"+Esc+"6n"+Esc+"u", cursorReport, timeout)
+	if !ok {
+		return 0, 0, false
+	}
+	h, _ := strconv.Atoi(reply[1])
+	w, _ := strconv.Atoi(reply[2])
+	if w == 0 || h == 0 {
+		return 0, 0, false
+	}
+	return w, h, true
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+// nawsReply is what a telnet client sends to report a w x h screen.
+func nawsReply(w, h int) []byte {
+	return []byte{telnetIAC, telnetSB, telnetNAWS, byte(w >> 8), byte(w), byte(h >> 8), byte(h), telnetIAC, telnetSE}
+}
+
+// fakeClient is the caller's end of a Terminal. answer sees each write the
+// door makes and returns what the client types back, if anything.
+type fakeClient struct {
+	keys   *io.PipeWriter
+	answer func(p []byte) []byte
+
+	mu   sync.Mutex
+	sent []byte
+}
+
+func (c *fakeClient) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	c.sent = append(c.sent, p...)
+	c.mu.Unlock()
+	if c.answer != nil {
+		if reply := c.answer(p); reply != nil {
+			go c.keys.Write(reply)
+		}
+	}
+	return len(p), nil
+}
+
+// typeKeys sends keys to the door as if the caller typed them.
+func (c *fakeClient) typeKeys(keys []byte) {
+	go c.keys.Write(keys)
+}
+
+// Sent reports whether the door has sent seq.
+func (c *fakeClient) Sent(seq string) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return bytes.Contains(c.sent, []byte(seq))
+}
+
+// newFakeClient connects a Terminal to a client over a pipe.
+func newFakeClient(t *testing.T, telnet bool, answer func(p []byte) []byte) (*Terminal, *fakeClient) {
+	r, w := io.Pipe()
+	t.Cleanup(func() { w.Close() })
+	c := &fakeClient{keys: w, answer: answer}
+	tm := NewTerminal(r, c, CharsetUTF8)
+	tm.Telnet = telnet
+	return tm, c
+}
+
+// answerNAWS agrees to NAWS and reports a w x h screen.
+func answerNAWS(w, h int) func(p []byte) []byte {
+	return func(p []byte) []byte {
+		if bytes.Equal(p, []byte{telnetIAC, telnetDo, telnetNAWS}) {
+			return append([]byte{telnetIAC, telnetWill, telnetNAWS}, nawsReply(w, h)...)
+		}
+		return nil
+	}
+}
+
+// answerDSR reports the cursor at row h, column w when asked, after
+// typing ahead keys the caller pressed before the reply went out.
+func answerDSR(w, h int, typedAhead string) func(p []byte) []byte {
+	return func(p []byte) []byte {
+		if bytes.Contains(p, []byte(Esc+"6n")) {
+			return []byte(typedAhead + Esc + strconv.Itoa(h) + ";" + strconv.Itoa(w) + "R")
+		}
+		return nil
+	}
+}
+
+func sizeTestConfig() Config {
+	c := defaultConfig()
+	c.ProbeTimeout = 100 * time.Millisecond
+	c.ScreenWidth, c.ScreenHeight = 80, 25
+	return c
+}
+
+func TestDetectTermSize(t *testing.T) {
+	refuse := func(p []byte) []byte {
+		if bytes.Equal(p, []byte{telnetIAC, telnetDo, telnetNAWS}) {
+			return []byte{telnetIAC, telnetWont, telnetNAWS}
+		}
+		return answerDSR(132, 50, "")(p)
+	}
+	tests := []struct {
+		name   string
+		telnet bool
+		ansi   bool
+		answer func(p []byte) []byte
+		w, h   int
+		source string
+	}{
+		{"naws", true, true, answerNAWS(100, 40), 100, 40, sizeFromNAWS},
+		{"naws refused", true, true, refuse, 132, 50, sizeFromDSR},
+		{"cursor report", false, true, answerDSR(90, 30, ""), 90, 30, sizeFromDSR},
+		{"silent", true, true, nil, 80, 25, sizeFromDefault},
+		{"no ansi", false, false, answerDSR(90, 30, ""), 80, 25, sizeFromDefault},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm, c := newFakeClient(t, tt.telnet, tt.answer)
+			w, h, source := detectTermSize(tm, -1, sizeTestConfig(), tt.ansi)
+			if w != tt.w || h != tt.h || source != tt.source {
+				t.Errorf("got %dx%d from %s, want %dx%d from %s", w, h, source, tt.w, tt.h, tt.source)
+			}
+			if !tt.ansi && c.Sent(Esc+"6n") {
+				t.Error("asked a terminal without ANSI for its cursor position")
+			}
+			if !tt.telnet && c.Sent(string([]byte{telnetIAC, telnetDo, telnetNAWS})) {
+				t.Error("asked for NAWS outside a telnet session")
+			}
+		})
+	}
+}
+
+func TestQuerySizeKeepsKeys(t *testing.T) {
+	tm, _ := newFakeClient(t, false, answerDSR(90, 30, "x"))
+	w, h, ok := querySize(tm, time.Second)
+	if !ok || w != 90 || h != 30 {
+		t.Fatalf("got %dx%d, %v, want 90x30", w, h, ok)
+	}
+	r, _, err := tm.GetKey()
+	if err != nil || r != 'x' {
+		t.Errorf("the key typed before the reply came back as %q, %v", r, err)
+	}
+}
+
+func TestQuerySizeTimesOut(t *testing.T) {
+	tm, _ := newFakeClient(t, false, nil)
+	start := time.Now()
+	if _, _, ok := querySize(tm, 100*time.Millisecond); ok {
+		t.Fatal("a silent terminal reported a size")
+	}
+	if waited := time.Since(start); waited > time.Second {
+		t.Errorf("waited %v for a 100ms timeout", waited)
+	}
+}
+
+func TestNAWSResize(t *testing.T) {
+	tm, c := newFakeClient(t, true, answerNAWS(80, 25))
+	var sizes [][2]int
+	tm.OnResize = func(w, h int) {
+		sizes = append(sizes, [2]int{w, h})
+		c.typeKeys([]byte("q")) // the key that shows the resize was handled first
+	}
+	if w, h, ok := tm.RequestNAWS(time.Second); !ok || w != 80 || h != 25 {
+		t.Fatalf("got %dx%d, %v, want 80x25", w, h, ok)
+	}
+
+	c.typeKeys(nawsReply(132, 50))
+	r, _, err := tm.GetKey()
+	if err != nil || r != 'q' {
+		t.Fatalf("got key %q, %v", r, err)
+	}
+	if len(sizes) != 1 || sizes[0] != [2]int{132, 50} {
+		t.Errorf("OnResize saw %v, want only 132x50", sizes)
+	}
+}
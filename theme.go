@@ -0,0 +1,453 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// ThemeManifest is the file in a theme directory that describes its layout.
+const ThemeManifest = "theme.cfg"
+
+// Region is a rectangle on the screen, in 1-based columns and rows.
+type Region struct {
+	X, Y, W, H int
+}
+
+// MenuItem places one hotkey of the menu.
+type MenuItem struct {
+	Key   string
+	Label string
+	X, Y  int
+}
+
+// Theme is a skin for the door: the stall art plus where each widget goes
+// and what colors it uses. Regions and colors a manifest leaves out fall
+// back to the classic layout.
+type Theme struct {
+	Name    string
+	Dir     string
+	Art     string // art file, relative to Dir
+	Regions map[string]Region
+	Colors  map[string]string // SGR sequences
+	Menu    []MenuItem
+	Palette [16]string // color specs for the pipe codes in posts
+	// Template lays out the message box; nil for the default. See template.go.
+	Template *template.Template
+}
+
+// classicTheme is the layout the door shipped with.
+func classicTheme() *Theme {
+	return &Theme{
+		Name: "classic",
+		Dir:  filepath.Join("themes", "classic"),
+		Art:  "toiletui.ans",
+		Regions: map[string]Region{
+			"message": {25, 12, 25, 5},
+			"prompt":  {56, 7, 24, 1},
+			"status":  {1, 24, 80, 1},
+			"author":  {56, 9, 24, 1},
+			"thread":  {56, 10, 24, 1},
+			"posted":  {56, 8, 24, 1},
+		},
+		Colors: map[string]string{
+			"message":        BgBlue + YellowHi,
+			"editor":         BgBlue + White,
+			"prompt":         YellowHi,
+			"notice":         RedHi,
+			"status":         BgBlue + WhiteHi,
+			"author":         Cyan,
+			"votes.keep":     GreenHi,
+			"votes.flush":    RedHi,
+			"thread":         Cyan,
+			"posted":         Cyan,
+			"bulletin.title": YellowHi,
+			"bulletin.frame": Cyan,
+			"pinned":         BgRed + YellowHi,
+			"pinned.border":  RedHi,
+			"menu.bracket":   Cyan,
+			"menu.key":       CyanHi,
+			"menu.label":     CyanHi,
+		},
+		Menu: []MenuItem{
+			{"A", "Add", 2, 10},
+			{"R", "Reply", 2, 11},
+			{"V", "Replies", 2, 12},
+			{"U", "Unread", 2, 13},
+			{"N", "Next", 2, 14},
+			{"P", "Previous", 2, 15},
+			{"F", "First", 2, 16},
+			{"L", "Last", 2, 17},
+			{"S", "Search", 2, 18},
+			{"O", "Options", 2, 19},
+			{"K", "Keep", 2, 20},
+			{"X", "Flush", 2, 21},
+			{"Q", "Quit", 2, 23},
+		},
+		Palette: defaultPalette,
+	}
+}
+
+var theme = classicTheme()
+
+// Region returns a named region of the theme.
+func (t *Theme) Region(name string) Region {
+	if r, ok := t.Regions[name]; ok {
+		return r
+	}
+	return classicTheme().Regions[name]
+}
+
+// Color returns the SGR sequence for a named color of the theme.
+func (t *Theme) Color(name string) string {
+	if c, ok := t.Colors[name]; ok {
+		return c
+	}
+	return classicTheme().Colors[name]
+}
+
+// PaletteColor returns the SGR sequence for pipe code color n, as a
+// foreground or a background.
+func (t *Theme) PaletteColor(n int, background bool) string {
+	spec := t.Palette[n]
+	if background {
+		spec = "on " + spec
+	}
+	seq, err := parseColor(spec)
+	if err != nil {
+		return ""
+	}
+	return seq
+}
+
+// ArtPath is the path of the theme's art file.
+func (t *Theme) ArtPath() string {
+	return filepath.Join(t.Dir, t.Art)
+}
+
+// LoadTheme reads the manifest in dir. A manifest starts from the classic
+// layout, so it only needs to list what it changes.
+func LoadTheme(dir string) (*Theme, error) {
+	path := filepath.Join(dir, ThemeManifest)
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	lines, err := readCfgLines(file)
+	if err != nil {
+		return nil, err
+	}
+
+	t := classicTheme()
+	t.Name = filepath.Base(dir)
+	t.Dir = dir
+	menuSet := false
+	for _, line := range lines {
+		if strings.EqualFold(line.Keyword, "Menu") && !menuSet {
+			t.Menu = nil // a manifest with a menu replaces the whole menu
+			menuSet = true
+		}
+		if err := t.apply(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, line.Num, err)
+		}
+	}
+	return t, nil
+}
+
+// apply sets the theme option named by a single manifest line.
+func (t *Theme) apply(line cfgLine) error {
+	switch strings.ToLower(line.Keyword) {
+	case "name":
+		t.Name = strings.Join(line.Args, " ")
+	case "art":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("Art takes one file name")
+		}
+		t.Art = line.Args[0]
+	case "region":
+		if len(line.Args) != 5 {
+			return fmt.Errorf("Region takes a name, column, row, width and height")
+		}
+		nums, err := atois(line.Args[1:])
+		if err != nil {
+			return err
+		}
+		t.Regions[strings.ToLower(line.Args[0])] = Region{nums[0], nums[1], nums[2], nums[3]}
+	case "color":
+		if len(line.Args) < 2 {
+			return fmt.Errorf("Color takes a name and a color")
+		}
+		seq, err := parseColor(strings.Join(line.Args[1:], " "))
+		if err != nil {
+			return err
+		}
+		t.Colors[strings.ToLower(line.Args[0])] = seq
+	case "menu":
+		if len(line.Args) < 4 {
+			return fmt.Errorf("Menu takes a hotkey, column, row and label")
+		}
+		nums, err := atois(line.Args[1:3])
+		if err != nil {
+			return err
+		}
+		t.Menu = append(t.Menu, MenuItem{
+			Key:   strings.ToUpper(line.Args[0]),
+			Label: strings.Join(line.Args[3:], " "),
+			X:     nums[0],
+			Y:     nums[1],
+		})
+	case "template":
+		if len(line.Args) != 1 {
+			return fmt.Errorf("Template takes one file name")
+		}
+		tmpl, err := loadMessageTemplate(filepath.Join(t.Dir, line.Args[0]))
+		if err != nil {
+			return err
+		}
+		t.Template = tmpl
+	case "palette":
+		if len(line.Args) != 2 {
+			return fmt.Errorf("Palette takes a pipe code color number and a color")
+		}
+		n, err := strconv.Atoi(line.Args[0])
+		if err != nil || n < 0 || n > 15 {
+			return fmt.Errorf("bad palette number %q, want 0 to 15", line.Args[0])
+		}
+		if _, err := parseColor(line.Args[1]); err != nil {
+			return err
+		}
+		t.Palette[n] = line.Args[1]
+	default:
+		return fmt.Errorf("unknown keyword %q", line.Keyword)
+	}
+	return nil
+}
+
+// colorNames are the PC colors in ANSI order.
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+// parseColor turns "yellow+ on blue" into an SGR sequence. A "+" makes the
+// foreground bright; a bright background turns on blink, which iCE color
+// terminals show as a bright background. Colors can also be given as
+// #rrggbb, which terminals without 24-bit color show as the nearest PC color.
+func parseColor(spec string) (string, error) {
+	fields := strings.Fields(strings.ToLower(spec))
+	var codes []string
+
+	fg := fields
+	var bg []string
+	for i, f := range fields {
+		if f == "on" {
+			fg, bg = fields[:i], fields[i+1:]
+			break
+		}
+	}
+	if len(fg) > 1 || len(bg) > 1 || (len(fg) == 0 && len(bg) == 0) {
+		return "", fmt.Errorf("bad color %q", spec)
+	}
+
+	if len(fg) == 1 && strings.HasPrefix(fg[0], "#") {
+		c, err := hexColor(fg[0])
+		if err != nil {
+			return "", err
+		}
+		codes = append(codes, "38;2;"+c)
+	} else if len(fg) == 1 {
+		n, bright, err := colorNumber(fg[0])
+		if err != nil {
+			return "", err
+		}
+		codes = append(codes, strconv.Itoa(30+n))
+		if bright {
+			codes = append(codes, "1")
+		}
+	}
+	if len(bg) == 1 && strings.HasPrefix(bg[0], "#") {
+		c, err := hexColor(bg[0])
+		if err != nil {
+			return "", err
+		}
+		codes = append(codes, "48;2;"+c)
+	} else if len(bg) == 1 {
+		n, bright, err := colorNumber(bg[0])
+		if err != nil {
+			return "", err
+		}
+		codes = append(codes, strconv.Itoa(40+n))
+		if bright {
+			codes = append(codes, "5")
+		}
+	}
+	return Esc + strings.Join(codes, ";") + "m", nil
+}
+
+func colorNumber(name string) (int, bool, error) {
+	bright := strings.HasSuffix(name, "+")
+	name = strings.TrimSuffix(name, "+")
+	if name == "brown" {
+		name = "yellow"
+	} else if name == "grey" || name == "gray" {
+		name = "white"
+	}
+	for i, c := range colorNames {
+		if c == name {
+			return i, bright, nil
+		}
+	}
+	return 0, false, fmt.Errorf("unknown color %q", name)
+}
+
+// hexColor turns #rrggbb into the "r;g;b" of a 24-bit SGR code.
+func hexColor(s string) (string, error) {
+	if len(s) != 7 {
+		return "", fmt.Errorf("bad color %q, want #rrggbb", s)
+	}
+	v, err := strconv.ParseUint(s[1:], 16, 32)
+	if err != nil {
+		return "", fmt.Errorf("bad color %q, want #rrggbb", s)
+	}
+	return fmt.Sprintf("%d;%d;%d", v>>16, v>>8&0xff, v&0xff), nil
+}
+
+func atois(args []string) ([]int, error) {
+	nums := make([]int, len(args))
+	for i, a := range args {
+		n, err := strconv.Atoi(a)
+		if err != nil {
+			return nil, fmt.Errorf("bad number %q", a)
+		}
+		nums[i] = n
+	}
+	return nums, nil
+}
+
+// ThemeSchedule switches to a theme between two dates (MM-DD) or two times
+// of day (HH:MM). Ranges may wrap around midnight or the new year.
+type ThemeSchedule struct {
+	From, To string
+	Theme    string
+}
+
+// matches reports whether now falls inside the schedule.
+func (s ThemeSchedule) matches(now time.Time) bool {
+	var cur string
+	if strings.Contains(s.From, ":") {
+		cur = now.Format("15:04")
+	} else {
+		cur = now.Format("01-02")
+	}
+	if s.From <= s.To {
+		return cur >= s.From && cur <= s.To
+	}
+	return cur >= s.From || cur <= s.To
+}
+
+// parseScheduleBound checks a schedule bound and normalizes it to MM-DD or
+// HH:MM with leading zeros, so bounds compare as strings.
+func parseScheduleBound(s string) (string, error) {
+	if t, err := time.Parse("15:04", s); err == nil {
+		return t.Format("15:04"), nil
+	}
+	if t, err := time.Parse("1-2", s); err == nil {
+		return t.Format("01-02"), nil
+	}
+	return "", fmt.Errorf("bad schedule time %q, want MM-DD or HH:MM", s)
+}
+
+// selectTheme picks the theme for a session starting at now: the first
+// schedule that matches, otherwise the configured theme. A theme that can't
+// be loaded is logged and falls back to the classic one.
+func selectTheme(cfg Config, now time.Time) *Theme {
+	name := cfg.Theme
+	for _, s := range cfg.ThemeSchedules {
+		if s.matches(now) {
+			name = s.Theme
+			break
+		}
+	}
+
+	t, err := LoadTheme(filepath.Join(cfg.ThemeDir, name))
+	if err != nil {
+		log.Printf("theme %s: %v; using the classic theme", name, err)
+		return classicTheme()
+	}
+	return t
+}
+
+// themeCommand handles "toilet-redux theme check <dir>...", which checks that
+// every region of each theme fits inside its art.
+func themeCommand(args []string) int {
+	if len(args) < 2 || args[0] != "check" {
+		fmt.Fprintln(os.Stderr, "usage: toilet-redux theme check <theme dir>...")
+		return 2
+	}
+
+	status := 0
+	for _, dir := range args[1:] {
+		problems, err := checkTheme(dir)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", dir, err)
+			status = 1
+			continue
+		}
+		for _, p := range problems {
+			fmt.Printf("%s: %s\n", dir, p)
+		}
+		if len(problems) > 0 {
+			status = 1
+		} else {
+			fmt.Printf("%s: ok\n", dir)
+		}
+	}
+	return status
+}
+
+// checkTheme loads a theme and lists the regions and menu items that don't
+// fit inside the art's SAUCE dimensions.
+func checkTheme(dir string) ([]string, error) {
+	t, err := LoadTheme(dir)
+	if err != nil {
+		return nil, err
+	}
+	art, err := ReadArt(t.ArtPath())
+	if err != nil {
+		return nil, err
+	}
+
+	w, h := art.Sauce.Width(), art.Sauce.Height()
+	if h == 0 {
+		h = screenRows
+	}
+
+	var problems []string
+	for _, name := range []string{"message", "prompt", "status", "author", "thread", "posted"} {
+		r := t.Region(name)
+		if r.X < 1 || r.Y < 1 || r.W < 1 || r.H < 1 || r.X+r.W-1 > w || r.Y+r.H-1 > h {
+			problems = append(problems, fmt.Sprintf("region %s (%d,%d %dx%d) doesn't fit the %dx%d art", name, r.X, r.Y, r.W, r.H, w, h))
+		}
+	}
+	if t.Template != nil {
+		sample := &Message{ID: 1, Body: "Testing |14one|07, two, three", Author: "SysOp", Posted: time.Now()}
+		view := messageView{ID: 1, Body: sample.Body, Text: renderMarkup(sample.Body, t.Color("message")),
+			Author: sample.Byline(), Posted: sample.Posted, Width: t.Region("message").W, Height: t.Region("message").H}
+		if _, err := runMessageTemplate(t.Template, &view); err != nil {
+			problems = append(problems, fmt.Sprintf("message template: %v", err))
+		}
+	}
+	for _, m := range t.Menu {
+		width := layout.Width("[" + m.Key + "] " + m.Label)
+		if m.X < 1 || m.Y < 1 || m.X+width-1 > w || m.Y > h {
+			problems = append(problems, fmt.Sprintf("menu item %s at %d,%d doesn't fit the %dx%d art", m.Key, m.X, m.Y, w, h))
+		}
+	}
+	return problems, nil
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSelectThemeLogsFallback(t *testing.T) {
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+	t.Cleanup(func() { log.SetOutput(os.Stderr) })
+
+	c := defaultConfig()
+	c.Theme = "gone"
+	th := selectTheme(c, time.Now())
+	if th == nil || th.ArtPath() != classicTheme().ArtPath() {
+		t.Errorf("got %+v, want the classic theme", th)
+	}
+	if !strings.Contains(logged.String(), "theme gone") {
+		t.Errorf("logged %q, want the missing theme named", logged.String())
+	}
+}
@@ -0,0 +1,70 @@
+//go:build linux || darwin || freebsd || netbsd || openbsd
+
+package main
+
+import (
+	"os"
+	"os/signal"
+
+	"golang.org/x/sys/unix"
+)
+
+// makeRaw puts the terminal on fd into raw mode so keys arrive one at a
+// time without echo, and returns a function that restores the old settings.
+// When fd isn't a terminal (a socket handed over by the BBS, or a pipe in
+// tests) there is nothing to change and the restore is a no-op.
+func makeRaw(fd int) (func(), error) {
+	orig, err := unix.IoctlGetTermios(fd, ioctlGetTermios)
+	if err != nil {
+		return func() {}, nil
+	}
+
+	tios := *orig
+	tios.Iflag &^= unix.IGNBRK | unix.BRKINT | unix.PARMRK | unix.ISTRIP |
+		unix.INLCR | unix.IGNCR | unix.ICRNL | unix.IXON
+	tios.Lflag &^= unix.ECHO | unix.ECHONL | unix.ICANON | unix.ISIG | unix.IEXTEN
+	tios.Cflag &^= unix.CSIZE | unix.PARENB
+	tios.Cflag |= unix.CS8
+	tios.Cc[unix.VMIN] = 1
+	tios.Cc[unix.VTIME] = 0
+
+	if err := unix.IoctlSetTermios(fd, ioctlSetTermios, &tios); err != nil {
+		return func() {}, err
+	}
+	return func() {
+		_ = unix.IoctlSetTermios(fd, ioctlSetTermios, orig)
+	}, nil
+}
+
+// ttySize returns the window size the tty on fd reports. A pty the BBS
+// never set a size on reports zeros, which count as unknown.
+func ttySize(fd int) (int, int, bool) {
+	ws, err := unix.IoctlGetWinsize(fd, unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row == 0 {
+		return 0, 0, false
+	}
+	return int(ws.Col), int(ws.Row), true
+}
+
+// isSocket reports whether fd is a network socket rather than a tty or pipe,
+// which means the BBS handed us the caller's raw telnet connection.
+func isSocket(fd int) bool {
+	var st unix.Stat_t
+	if err := unix.Fstat(fd, &st); err != nil {
+		return false
+	}
+	return st.Mode&unix.S_IFMT == unix.S_IFSOCK
+}
+
+// watchResize passes the tty's new size to t whenever the window changes.
+func watchResize(fd int, t *Terminal) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, unix.SIGWINCH)
+	go func() {
+		for range winch {
+			if w, h, ok := ttySize(fd); ok {
+				t.Resized(w, h)
+			}
+		}
+	}()
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// openPty opens a pseudo-terminal and returns the fd of its tty end, sized
+// w x h. Both ends are closed when the test ends.
+func openPty(t *testing.T, w, h int) int {
+	t.Helper()
+	ptmx, err := os.OpenFile("/dev/ptmx", os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Skipf("no pseudo-terminals here: %v", err)
+	}
+	t.Cleanup(func() { ptmx.Close() })
+	if err := unix.IoctlSetPointerInt(int(ptmx.Fd()), unix.TIOCSPTLCK, 0); err != nil {
+		t.Fatal(err)
+	}
+	n, err := unix.IoctlGetInt(int(ptmx.Fd()), unix.TIOCGPTN)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tty, err := os.OpenFile(fmt.Sprintf("/dev/pts/%d", n), os.O_RDWR|unix.O_NOCTTY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tty.Close() })
+	setPtySize(t, int(tty.Fd()), w, h)
+	return int(tty.Fd())
+}
+
+func setPtySize(t *testing.T, fd, w, h int) {
+	t.Helper()
+	if err := unix.IoctlSetWinsize(fd, unix.TIOCSWINSZ, &unix.Winsize{Col: uint16(w), Row: uint16(h)}); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDetectTermSizeFromTTY(t *testing.T) {
+	fd := openPty(t, 100, 40)
+	tm, c := newFakeClient(t, false, answerDSR(90, 30, ""))
+	w, h, source := detectTermSize(tm, fd, sizeTestConfig(), true)
+	if w != 100 || h != 40 || source != sizeFromTTY {
+		t.Errorf("got %dx%d from %s, want 100x40 from %s", w, h, source, sizeFromTTY)
+	}
+	if c.Sent(Esc + "6n") {
+		t.Error("asked for the cursor position when the tty knew the size")
+	}
+
+	// A pty nobody sized reports zeros, which aren't a size
+	setPtySize(t, fd, 0, 0)
+	if w, h, source := detectTermSize(tm, fd, sizeTestConfig(), true); source != sizeFromDSR {
+		t.Errorf("an unsized pty gave %dx%d from %s", w, h, source)
+	}
+}
+
+func TestWatchResize(t *testing.T) {
+	fd := openPty(t, 80, 25)
+	tm, c := newFakeClient(t, false, nil)
+	sizes := make(chan [2]int, 1)
+	tm.OnResize = func(w, h int) {
+		sizes <- [2]int{w, h}
+		c.typeKeys([]byte("q"))
+	}
+	watchResize(fd, tm)
+
+	// The kernel signals the tty's foreground process group, which a test
+	// isn't; send the signal by hand once the window has changed.
+	setPtySize(t, fd, 132, 50)
+	if err := unix.Kill(os.Getpid(), unix.SIGWINCH); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := tm.GetKey()
+		done <- err
+	}()
+	select {
+	case size := <-sizes:
+		if size != [2]int{132, 50} {
+			t.Errorf("OnResize saw %v, want 132x50", size)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("SIGWINCH never reached OnResize")
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/eiannone/keyboard"
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// Size of the screen buffer; the theme places widgets inside it.
+const (
+	screenCols = 80
+	screenRows = 25
+)
+
+var (
+	scr          = NewScreen(screenCols, screenRows)
+	stallArt     *Art // loaded once, redrawn from memory
+	sessionStart = time.Now()
+	noticeTime   = time.Second // how long a notice stays up
+)
+
+// loadStallArt reads the theme's art the first time it's needed and
+// switches the terminal to the font and color mode its SAUCE record asks for.
+func loadStallArt() *Art {
+	if stallArt != nil {
+		return stallArt
+	}
+	path := theme.ArtPath()
+	art, err := ReadArt(path)
+	if err != nil {
+		log.Fatalf("Error reading file %s: %v", path, err)
+	}
+	applySauceModes(art.Sauce)
+	stallArt = art
+	return art
+}
+
+// drawArt paints the art into the screen buffer, one row at a time, and
+// makes it the background that widgets are cleared back to.
+func drawArt(art *Art) {
+	scr.Clear()
+	for i, row := range art.Rows() {
+		if i >= scr.H {
+			break
+		}
+		scr.PrintAt(1, i+1, Reset+row)
+	}
+	scr.SaveBackground()
+}
+
+// drawMenu paints the theme's hotkey menu.
+func drawMenu() {
+	for _, m := range theme.Menu {
+		scr.PrintAt(m.X, m.Y, theme.Color("menu.bracket")+"["+theme.Color("menu.key")+m.Key+Reset+
+			theme.Color("menu.bracket")+"]"+theme.Color("menu.label")+" "+m.Label+Reset)
+	}
+}
+
+// drawMessageBox paints already formatted lines into the stall message box.
+func drawMessageBox(lines []string) {
+	box := theme.Region("message")
+	for i, line := range lines {
+		if i >= box.H {
+			break
+		}
+		scr.PrintAt(box.X, box.Y+i, theme.Color("message")+line+Reset)
+	}
+}
+
+// drawPinnedBox paints a pinned post's lines into the message box in the
+// theme's pinned colors, filled out to the edges and framed, so sysop
+// announcements stand apart from the graffiti.
+func drawPinnedBox(lines []string) {
+	box := theme.Region("message")
+	border, color := theme.Color("pinned.border"), theme.Color("pinned")
+	label := "─ Pinned "
+	scr.PrintAt(box.X-1, box.Y-1, border+"┌"+label+strings.Repeat("─", max(box.W-layout.Width(label), 0))+"┐"+Reset)
+	for i := 0; i < box.H; i++ {
+		line := ""
+		if i < len(lines) {
+			line = lines[i]
+		}
+		line = layout.PadRight(line, box.W)
+		scr.PrintAt(box.X-1, box.Y+i, border+"│"+Reset+color+line+Reset+border+"│"+Reset)
+	}
+	scr.PrintAt(box.X-1, box.Y+box.H, border+"└"+strings.Repeat("─", box.W)+"┘"+Reset)
+}
+
+// drawPost paints a post into the message box, pinned or not.
+func drawPost(m *Message) {
+	box := theme.Region("message")
+	if m.Pinned {
+		drawPinnedBox(formatMessage(m, theme.Color("pinned"), box.W, box.H))
+		return
+	}
+	drawMessageBox(formatMessage(m, theme.Color("message"), box.W, box.H))
+}
+
+// editorRows lays text being typed out in rows width columns wide, the
+// way drawEditor paints it, and says where the cursor goes after it. A wide
+// character that won't fit at the end of a row starts the next one.
+func editorRows(runes []markupRune, width int) (rows [][]markupRune, col, row int) {
+	rows = [][]markupRune{nil}
+	for _, r := range runes {
+		w := layout.RuneWidth(r.Ch)
+		if col+w > width {
+			rows = append(rows, nil)
+			col = 0
+		}
+		rows[len(rows)-1] = append(rows[len(rows)-1], r)
+		col += w
+	}
+	row = len(rows) - 1
+	if col >= width {
+		col, row = 0, row+1
+	}
+	return rows, col, row
+}
+
+// drawEditor paints text being typed into the message box as a plain grid,
+// one box width to a row, so the cursor position is predictable. Pipe codes
+// show as the colors they pick and take up no room. It returns the column
+// and row, from the box's corner, that the cursor goes to.
+func drawEditor(text []rune) (col, row int) {
+	box := theme.Region("message")
+	base := theme.Color("editor")
+	rows, col, row := editorRows(parseMarkup(string(text), base), box.W)
+	for y := 0; y < box.H; y++ {
+		var line strings.Builder
+		width := 0
+		if y < len(rows) {
+			for _, r := range rows[y] {
+				line.WriteString(r.SGR + string(r.Ch))
+				width += layout.RuneWidth(r.Ch)
+			}
+		}
+		line.WriteString(base + strings.Repeat(" ", max(box.W-width, 0)))
+		scr.PrintAt(box.X, box.Y+y, line.String()+Reset)
+	}
+	return col, row
+}
+
+// drawByline paints who wrote a message and how the votes on it stand.
+func drawByline(m *Message) {
+	r := theme.Region("author")
+	scr.RestoreBackground(r.X, r.Y, r.W, r.H)
+	votes := fmt.Sprintf(" +%d -%d", m.Keep, m.Flush)
+	name := layout.Truncate(m.Byline(), r.W-layout.Width("by "+votes), "")
+	scr.PrintAt(r.X, r.Y, theme.Color("author")+"by "+name+Reset+
+		theme.Color("votes.keep")+fmt.Sprintf(" +%d", m.Keep)+Reset+
+		theme.Color("votes.flush")+fmt.Sprintf(" -%d", m.Flush)+Reset)
+}
+
+// drawPosted paints when the message on screen was written.
+func drawPosted(m *Message) {
+	r := theme.Region("posted")
+	scr.RestoreBackground(r.X, r.Y, r.W, r.H)
+	scr.PrintAt(r.X, r.Y, theme.Color("posted")+showTime(m.Posted, time.Now())+Reset)
+}
+
+// drawThread paints where the message on screen sits in its thread.
+func drawThread(text string) {
+	r := theme.Region("thread")
+	scr.RestoreBackground(r.X, r.Y, r.W, r.H)
+	scr.PrintAt(r.X, r.Y, theme.Color("thread")+text+Reset)
+}
+
+// drawPrompt replaces the prompt line with text, restoring the art
+// underneath whatever was there before.
+func drawPrompt(text string) {
+	r := theme.Region("prompt")
+	scr.RestoreBackground(r.X, r.Y, r.W, r.H)
+	scr.PrintAt(r.X, r.Y, text)
+}
+
+// drawStatus paints the status bar.
+func drawStatus() {
+	r := theme.Region("status")
+	left := u.TimeLeft - time.Since(sessionStart)
+	if left < 0 {
+		left = 0
+	}
+	status := fmt.Sprintf(" %s  Node %d", u.Alias, u.NodeNum)
+	timeText := fmt.Sprintf("%d min left ", int(left.Minutes()))
+	pad := r.W - layout.Width(status) - layout.Width(timeText)
+	if pad < 1 {
+		pad = 1
+	}
+	middle := strings.Repeat(" ", pad)
+	if notice := unreadNotice(); notice != "" && layout.Width(notice)+4 <= pad {
+		middle = layout.Center(notice, pad)
+	}
+	scr.PrintAt(r.X, r.Y, theme.Color("status")+status+middle+timeText+Reset)
+}
+
+// refresh sends everything drawn since the last refresh to the caller.
+func refresh() {
+	if err := scr.Flush(term); err != nil {
+		panic(err)
+	}
+}
+
+func min(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// UI is how the wall is presented to a caller. The wall logic in main.go
+// runs the same on top of every implementation.
+type UI interface {
+	// ShowWall draws the wall with a message on it, or bare when m is nil.
+	ShowWall(m *Message)
+	// ShowReply draws reply n of total to parent.
+	ShowReply(parent, reply *Message, n, total int)
+	// ShowMenu gets the caller ready to press a command key.
+	ShowMenu()
+	// Compose lets the caller write a message of up to limit characters.
+	Compose(limit int) string
+	// Confirm asks a yes or no question.
+	Confirm(prompt string) bool
+	// Ask asks a yes or no question that Enter answers with def.
+	Ask(prompt string, def bool) bool
+	// Flush plays the flush animation over the message box.
+	Flush()
+	// Notice shows a short message for a moment.
+	Notice(text string)
+	// Hint shows a short message that stays until the screen changes.
+	Hint(text string)
+	// Input reads a line of up to limit characters after prompt.
+	Input(prompt string, limit int) string
+	// Goodbye signs off at the end of the session.
+	Goodbye()
+}
+
+var ui UI = &screenUI{}
+
+// newUI picks the presentation for a drop file emulation value: line mode
+// for plain ASCII callers, the full screen for ANSI and Avatar.
+func newUI(emulation int) UI {
+	if emulation == 0 {
+		return &lineUI{}
+	}
+	scr.Out = backendFor(emulation, caps)
+	return &screenUI{}
+}
+
+// screenUI draws the stall art and widgets through the screen buffer, using
+// cursor addressing to put everything in its place.
+type screenUI struct{}
+
+func (screenUI) ShowWall(m *Message) {
+	reloadScreen()
+	drawMenu()
+	if m == nil {
+		return
+	}
+	drawPost(m)
+	drawByline(m)
+	drawPosted(m)
+	if m.Replies == 1 {
+		drawThread("1 reply, [V] to read")
+	} else if m.Replies > 1 {
+		drawThread(fmt.Sprintf("%d replies, [V] to read", m.Replies))
+	}
+}
+
+func (screenUI) ShowReply(parent, reply *Message, n, total int) {
+	reloadScreen()
+	drawMenu()
+	box := theme.Region("message")
+	drawMessageBox(formatMessage(reply, theme.Color("message"), box.W, box.H))
+	drawByline(reply)
+	drawPosted(reply)
+	drawThread(fmt.Sprintf("Reply %d of %d, [Q] back", n, total))
+}
+
+func (screenUI) ShowMenu() {
+	drawMenu()
+	refresh()
+}
+
+func (screenUI) Compose(limit int) string {
+	reloadScreen()
+	drawPrompt(theme.Color("prompt") + "Press ENTER when done." + Reset)
+
+	box := theme.Region("message")
+	limit = min(limit, box.W*box.H)
+	var text []rune
+	for {
+		col, row := drawEditor(text)
+		refresh()
+		scr.MoveCursor(term, box.X+col, box.Y+row)
+		scr.Out.Cursor(term, true)
+
+		char, key := getKey()
+		if key == keyboard.KeyEnter {
+			break
+		}
+		switch {
+		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+			}
+		case key == keyboard.KeySpace:
+			text = append(text, ' ')
+		case char != 0:
+			text = append(text, char)
+		}
+		rows, _, row := editorRows(parseMarkup(string(text), ""), box.W)
+		if len(rows) > box.H {
+			text = text[:len(text)-1] // a wide character with no room left for it
+		} else if row >= box.H || visibleLen(string(text)) >= limit || !roomFor(text) {
+			drawEditor(text)
+			break // Stop if maximum rows reached
+		}
+	}
+
+	scr.Out.Cursor(term, false)
+	return string(text)
+}
+
+func (screenUI) Confirm(prompt string) bool {
+	drawPrompt(theme.Color("prompt") + prompt + Reset)
+	refresh()
+	return waitYesNo()
+}
+
+func (screenUI) Ask(prompt string, def bool) bool {
+	drawPrompt(theme.Color("prompt") + prompt + " " + yesNoHint(def) + Reset)
+	refresh()
+	return waitYesNoEnter(def)
+}
+
+// flushFrames are the water swirling round the message box as it goes.
+var flushFrames = []string{"~-~-", "≈~≈~", "°≈°≈", "∙°∙°", "  ∙ ", "    "}
+
+// Flush swirls the message off the wall.
+func (screenUI) Flush() {
+	box := theme.Region("message")
+	for i, frame := range flushFrames {
+		for y := 0; y < box.H; y++ {
+			var line strings.Builder
+			for x := 0; x < box.W; x++ {
+				line.WriteRune([]rune(frame)[(x+y*2+i)%4])
+			}
+			scr.PrintAt(box.X, box.Y+y, theme.Color("message")+line.String()+Reset)
+		}
+		refresh()
+		time.Sleep(120 * time.Millisecond)
+	}
+}
+
+func (screenUI) Notice(text string) {
+	drawPrompt(theme.Color("notice") + text + Reset)
+	refresh()
+	time.Sleep(noticeTime)
+}
+
+func (screenUI) Hint(text string) {
+	drawPrompt(theme.Color("prompt") + text + Reset)
+}
+
+// Input edits a line in the prompt region, scrolling it sideways when it
+// gets longer than the region.
+func (screenUI) Input(prompt string, limit int) string {
+	r := theme.Region("prompt")
+	room := max(r.W-layout.Width(prompt)-1, 1)
+	var text []rune
+	for {
+		shown := text
+		for typedWidth(shown) > room {
+			shown = shown[1:]
+		}
+		drawPrompt(theme.Color("prompt") + prompt + theme.Color("editor") + string(shown) + Reset)
+		refresh()
+		scr.MoveCursor(term, r.X+layout.Width(prompt)+typedWidth(shown), r.Y)
+		scr.Out.Cursor(term, true)
+
+		char, key := getKey()
+		switch {
+		case key == keyboard.KeyEnter:
+			scr.Out.Cursor(term, false)
+			return string(text)
+		case key == keyboard.KeyEsc:
+			scr.Out.Cursor(term, false)
+			return ""
+		case key == keyboard.KeyBackspace || key == keyboard.KeyBackspace2:
+			if len(text) > 0 {
+				text = text[:len(text)-1]
+			}
+		case key == keyboard.KeySpace && len(text) < limit:
+			text = append(text, ' ')
+		case char != 0 && len(text) < limit:
+			text = append(text, char)
+		}
+	}
+}
+
+// typedWidth is how many columns typed text takes on screen, any pipe
+// codes in it shown as they were typed.
+func typedWidth(text []rune) int {
+	width := 0
+	for _, r := range text {
+		width += layout.RuneWidth(r)
+	}
+	return width
+}
+
+func (screenUI) Goodbye() {
+	drawPrompt(theme.Color("prompt") + "Goodbye!" + Reset)
+	refresh()
+	time.Sleep(time.Duration(1) * time.Second)
+	scr.MoveCursor(term, 1, min(u.H, scr.H))
+	scr.Out.Cursor(term, true)
+	resetSauceModes(loadStallArt().Sauce)
+}
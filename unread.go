@@ -0,0 +1,78 @@
+package main
+
+import "fmt"
+
+// unread holds the posts written since the caller's last visit that they
+// haven't looked at yet this session. Until it's empty, N walks through
+// them instead of the whole wall.
+var unread = map[int]bool{}
+
+// readPosts holds the posts the caller has read this session.
+var readPosts = map[int]bool{}
+
+// onEntry is set while the entry screen goes up. The post it shows was put
+// in front of the caller rather than picked, so it doesn't count as read.
+var onEntry bool
+
+// findUnread fills unread from the caller's last read pointer, as it stood
+// when they came in.
+func findUnread() {
+	for _, id := range postsAfter(profile.LastRead, nil) {
+		unread[id] = true
+	}
+}
+
+// unreadNotice is what the status bar says about unread posts, or "".
+func unreadNotice() string {
+	switch n := len(unread); n {
+	case 0:
+		return ""
+	case 1:
+		return "1 new scrawl since your last visit"
+	default:
+		return fmt.Sprintf("%d new scrawls since your last visit", n)
+	}
+}
+
+// nextUnread returns the first unread post after id from, going round to
+// the first one when there's none after it, or nil once they're all read.
+// Posts flushed in the meantime are dropped on the way.
+func nextUnread(from int) *Message {
+	for len(unread) > 0 {
+		next, first := 0, 0
+		for id := range unread {
+			if first == 0 || id < first {
+				first = id
+			}
+			if id > from && (next == 0 || id < next) {
+				next = id
+			}
+		}
+		if next == 0 {
+			next = first
+		}
+		m, ok, err := store.Get(next)
+		if err != nil {
+			panic(err)
+		}
+		if ok && !m.Hidden {
+			return &m
+		}
+		delete(unread, next)
+	}
+	return nil
+}
+
+// loadFirstUnread jumps to the oldest post the caller hasn't read, leaving
+// any search behind.
+func loadFirstUnread() {
+	m := nextUnread(0)
+	if m == nil {
+		ui.Notice("Nothing new since your last visit.")
+		showCurrent()
+		return
+	}
+	search = nil
+	pinRun = nil
+	showPost(m)
+}
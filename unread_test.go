@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestMarkReadStopsAtUnseenPosts(t *testing.T) {
+	savedStore, savedUser, savedProfile, savedRead := store, u, profile, readPosts
+	t.Cleanup(func() { store, u, profile, readPosts = savedStore, savedUser, savedProfile, savedRead })
+	store, _ = newTestStore(t, "flat", Message{Body: "one", Author: "bravo"}, Message{Body: "two", Author: "bravo"})
+	u = User{Alias: "aLPHA", UserNum: 1}
+	profile = newProfile(u.Alias, u.UserNum)
+	readPosts = map[int]bool{}
+
+	lastRead := func(want int) {
+		t.Helper()
+		saved, err := store.Profile(u.Alias, u.UserNum)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if profile.LastRead != want || saved.LastRead != want {
+			t.Errorf("last read %d, saved as %d, want %d", profile.LastRead, saved.LastRead, want)
+		}
+	}
+	markRead(2)
+	lastRead(0) // the first post is still new
+	markRead(1)
+	lastRead(2)
+
+	// Two posts come in from another node while the caller is on, and they
+	// jump to the newest.
+	for _, body := range []string{"three", "four"} {
+		if err := store.Post(&Message{Body: body, Author: "charlie"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	markRead(4)
+	lastRead(2)
+	markRead(3)
+	lastRead(4)
+}
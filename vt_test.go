@@ -0,0 +1,347 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/robbiew/toilet-redux/layout"
+)
+
+// vt is a small ANSI terminal for tests. It takes what the door sends and
+// keeps the screen a caller would see: cursor movement, colors and erases
+// are interpreted, anything it doesn't know is skipped. It is written
+// separately from Screen on purpose, so the two can't share a bug.
+type vt struct {
+	w, h  int
+	cells []vtCell
+
+	x, y       int // 0-based cursor
+	wrapNext   bool
+	savedX     int
+	savedY     int
+	cursorShow bool
+
+	fg, bg int // PC colors, 0-15 and 0-7
+	bold   bool
+	blink  bool
+
+	pending []byte // an escape sequence or rune split across writes
+}
+
+// vtCell is one character position. tail marks the right half of a wide
+// character.
+type vtCell struct {
+	ch     rune
+	fg, bg int
+	blink  bool
+	tail   bool
+}
+
+// vtColor maps ANSI color offsets to PC color numbers.
+var vtColor = [8]int{0, 4, 2, 6, 1, 5, 3, 7}
+
+func newVT(w, h int) *vt {
+	v := &vt{w: w, h: h, cells: make([]vtCell, w*h), cursorShow: true}
+	v.resetPen()
+	v.erase(0, len(v.cells))
+	return v
+}
+
+func (v *vt) resetPen() {
+	v.fg, v.bg, v.bold, v.blink = 7, 0, false, false
+}
+
+func (v *vt) blank() vtCell {
+	return vtCell{ch: ' ', fg: 7, bg: v.bg}
+}
+
+func (v *vt) erase(from, to int) {
+	for i := from; i < to && i < len(v.cells); i++ {
+		v.cells[i] = v.blank()
+	}
+}
+
+func (v *vt) Write(p []byte) (int, error) {
+	data := append(v.pending, p...)
+	v.pending = nil
+	for len(data) > 0 {
+		if data[0] == 0x1b {
+			n := v.escape(data)
+			if n == 0 {
+				v.pending = append([]byte(nil), data...)
+				break
+			}
+			data = data[n:]
+			continue
+		}
+		r, size := utf8.DecodeRune(data)
+		if r == utf8.RuneError && !utf8.FullRune(data) {
+			v.pending = append([]byte(nil), data...)
+			break
+		}
+		v.put(r)
+		data = data[size:]
+	}
+	return len(p), nil
+}
+
+// escape runs the escape sequence data starts with and returns its length,
+// or 0 if it isn't all there yet.
+func (v *vt) escape(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	switch data[1] {
+	case '[':
+	case 'P': // a device control string, up to ESC \
+		for i := 2; i+1 < len(data); i++ {
+			if data[i] == 0x1b && data[i+1] == '\\' {
+				return i + 2
+			}
+		}
+		return 0
+	default:
+		return 2
+	}
+
+	end := 2
+	for end < len(data) && (data[end] < 0x40 || data[end] > 0x7e) {
+		end++
+	}
+	if end == len(data) {
+		return 0
+	}
+	params, final := string(data[2:end]), data[end]
+	if strings.IndexFunc(params, func(r rune) bool { return r >= 0x20 && r <= 0x2f }) >= 0 {
+		return end + 1 // font selection and the like
+	}
+	if strings.HasPrefix(params, "?") {
+		switch params + string(final) {
+		case "?25h":
+			v.cursorShow = true
+		case "?25l":
+			v.cursorShow = false
+		}
+		return end + 1
+	}
+	if strings.HasPrefix(params, "=") {
+		return end + 1 // CTerm's private modes
+	}
+
+	var args []int
+	for _, f := range strings.Split(params, ";") {
+		n, _ := strconv.Atoi(f)
+		args = append(args, n)
+	}
+	arg := func(i, def int) int {
+		if i < len(args) && args[i] > 0 {
+			return args[i]
+		}
+		return def
+	}
+
+	v.wrapNext = false
+	switch final {
+	case 'H', 'f':
+		v.moveTo(arg(1, 1)-1, arg(0, 1)-1)
+	case 'A':
+		v.moveTo(v.x, v.y-arg(0, 1))
+	case 'B':
+		v.moveTo(v.x, v.y+arg(0, 1))
+	case 'C':
+		v.moveTo(v.x+arg(0, 1), v.y)
+	case 'D':
+		v.moveTo(v.x-arg(0, 1), v.y)
+	case 'G':
+		v.moveTo(arg(0, 1)-1, v.y)
+	case 'J':
+		at := v.y*v.w + v.x
+		switch args[0] {
+		case 0:
+			v.erase(at, len(v.cells))
+		case 1:
+			v.erase(0, at+1)
+		case 2:
+			v.erase(0, len(v.cells))
+		}
+	case 'K':
+		row := v.y * v.w
+		switch args[0] {
+		case 0:
+			v.erase(row+v.x, row+v.w)
+		case 1:
+			v.erase(row, row+v.x+1)
+		case 2:
+			v.erase(row, row+v.w)
+		}
+	case 'm':
+		v.sgr(args)
+	case 's':
+		v.savedX, v.savedY = v.x, v.y
+	case 'u':
+		v.moveTo(v.savedX, v.savedY)
+	}
+	return end + 1
+}
+
+func (v *vt) moveTo(x, y int) {
+	v.x = min(max(x, 0), v.w-1)
+	v.y = min(max(y, 0), v.h-1)
+}
+
+func (v *vt) sgr(args []int) {
+	for i := 0; i < len(args); i++ {
+		switch n := args[i]; {
+		case n == 0:
+			v.resetPen()
+		case n == 1:
+			v.bold = true
+		case n == 5:
+			v.blink = true
+		case n == 22:
+			v.bold = false
+		case n == 25:
+			v.blink = false
+		case n >= 30 && n <= 37:
+			v.fg = vtColor[n-30]
+		case n == 39:
+			v.fg = 7
+		case n >= 40 && n <= 47:
+			v.bg = vtColor[n-40]
+		case n == 49:
+			v.bg = 0
+		case n >= 90 && n <= 97:
+			v.fg = vtColor[n-90] + 8
+		case n >= 100 && n <= 107:
+			v.bg, v.blink = vtColor[n-100], true
+		case n == 38 || n == 48: // extended colors aren't tracked
+			if i+1 < len(args) && args[i+1] == 5 {
+				i += 2
+			} else {
+				i += 4
+			}
+		}
+	}
+}
+
+func (v *vt) put(r rune) {
+	switch r {
+	case '\r':
+		v.x, v.wrapNext = 0, false
+		return
+	case '\n':
+		v.lineFeed()
+		return
+	case '\b':
+		if v.x > 0 {
+			v.x--
+		}
+		v.wrapNext = false
+		return
+	}
+	if r < 0x20 {
+		return
+	}
+	w := layout.RuneWidth(r)
+	if w == 0 {
+		return
+	}
+	if v.wrapNext || v.x+w > v.w {
+		v.x, v.wrapNext = 0, false
+		v.lineFeed()
+	}
+	fg := v.fg
+	if v.bold && fg < 8 {
+		fg += 8
+	}
+	c := vtCell{ch: r, fg: fg, bg: v.bg, blink: v.blink}
+	v.cells[v.y*v.w+v.x] = c
+	if w == 2 {
+		c.ch, c.tail = ' ', true
+		v.cells[v.y*v.w+v.x+1] = c
+	}
+	if v.x+w >= v.w {
+		v.x, v.wrapNext = v.w-1, true
+	} else {
+		v.x += w
+	}
+}
+
+func (v *vt) lineFeed() {
+	if v.y < v.h-1 {
+		v.y++
+		return
+	}
+	copy(v.cells, v.cells[v.w:])
+	v.erase(len(v.cells)-v.w, len(v.cells))
+}
+
+// Row returns the text on a 0-based row, trailing spaces and all.
+func (v *vt) Row(y int) string {
+	var b strings.Builder
+	for _, c := range v.cells[y*v.w : (y+1)*v.w] {
+		if !c.tail {
+			b.WriteRune(c.ch)
+		}
+	}
+	return b.String()
+}
+
+// Snapshot is the whole screen as text: the characters, then the
+// foreground and background colors of every cell as PC color numbers in
+// hex, G to N standing for backgrounds that blink, or are bright with iCE
+// colors, then the cursor.
+func (v *vt) Snapshot() string {
+	var b strings.Builder
+	b.WriteString("-- text --\n")
+	for y := 0; y < v.h; y++ {
+		b.WriteString(strings.TrimRight(v.Row(y), " ") + "\n")
+	}
+	b.WriteString("-- foreground --\n")
+	for y := 0; y < v.h; y++ {
+		for _, c := range v.cells[y*v.w : (y+1)*v.w] {
+			fmt.Fprintf(&b, "%x", c.fg)
+		}
+		b.WriteString("\n")
+	}
+	b.WriteString("-- background --\n")
+	for y := 0; y < v.h; y++ {
+		for _, c := range v.cells[y*v.w : (y+1)*v.w] {
+			if c.blink {
+				b.WriteRune('G' + rune(c.bg))
+			} else {
+				fmt.Fprintf(&b, "%x", c.bg)
+			}
+		}
+		b.WriteString("\n")
+	}
+	fmt.Fprintf(&b, "-- cursor --\n%d,%d", v.x+1, v.y+1)
+	if v.cursorShow {
+		b.WriteString(" shown\n")
+	} else {
+		b.WriteString(" hidden\n")
+	}
+	return b.String()
+}
+
+func TestVT(t *testing.T) {
+	v := newVT(10, 3)
+	io.WriteString(v, "hello\x1b[2;3Hab\x1b[1;33mc\x1b[0m\x1b[1;1H\x1b[Kx")
+	io.WriteString(v, "\x1b[2;9H漢字\x1b[?25l") // the second wide character wraps
+	want := []string{"x", "  abc   漢", "字"}
+	for y, row := range want {
+		if got := strings.TrimRight(v.Row(y), " "); got != row {
+			t.Errorf("row %d = %q, want %q", y+1, got, row)
+		}
+	}
+	if c := v.cells[1*10+4]; c.fg != 14 {
+		t.Errorf("bold yellow c has color %d, want 14", c.fg)
+	}
+	if v.cursorShow {
+		t.Error("cursor still shown after ?25l")
+	}
+}